@@ -4,7 +4,7 @@
 set -e
 
 VERSION=$(git describe --tags --always --dirty --long)
-MAIN_PACKAGE="../cmd/main.go"
+MAIN_PACKAGE="../cmd"
 OUTPUT_NAME="go-mirror-zig"
 
 mkdir -p release
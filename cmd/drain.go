@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/handlers"
+)
+
+// Time the fills that were abandoned at the end of the drain get to clean up.
+const abandonedFillsTimeout = 5 * time.Second
+
+func logDrainReport(report handlers.DrainReport) {
+	logger := slog.With(
+		"completed_transfers", report.CompletedTransfers,
+		"abandoned_transfers", report.AbandonedTransfers,
+		"completed_fills", report.CompletedFills,
+		"abandoned_fills", report.AbandonedFills,
+	)
+
+	if report.AbandonedTransfers > 0 || len(report.AbandonedFills) > 0 {
+		logger.Warn("drain finished, queued fills or downloads still in progress at the deadline were abandoned")
+		return
+	}
+	logger.Info("drain finished")
+}
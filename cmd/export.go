@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/savalione/go-mirror-zig/handlers"
+	"github.com/savalione/go-mirror-zig/internal/config"
+)
+
+// Writes the cached artifacts selected by -versions and -platforms into the -export bundle.
+// Selected artifacts that are not cached are logged, artifacts that fail verification fail the export.
+func exportBundle(ctx context.Context, cfg config.Config, cache *handlers.Cache) error {
+	slog.Info("export started", "output", cfg.Export, "versions", cfg.Versions, "platforms", cfg.Platforms)
+
+	report, err := cache.Export(ctx, handlers.ExportOptions{
+		Versions:  cfg.Versions,
+		Platforms: cfg.Platforms,
+		Output:    cfg.Export,
+	})
+	if err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+
+	slog.Info("export completed",
+		"output", cfg.Export,
+		"artifacts", report.Artifacts,
+		"bytes", report.Bytes,
+		"not_cached", len(report.Missing),
+		"corrupt", len(report.Corrupt),
+	)
+	if len(report.Missing) > 0 {
+		slog.Warn("some selected artifacts are not cached and were not exported, run -prefetch first", "artifacts", report.Missing)
+	}
+
+	if len(report.Corrupt) > 0 {
+		return fmt.Errorf("%d cached artifacts failed verification and were not exported, run -verify", len(report.Corrupt))
+	}
+	return nil
+}
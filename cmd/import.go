@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/savalione/go-mirror-zig/handlers"
+	"github.com/savalione/go-mirror-zig/internal/config"
+)
+
+// Imports the Zig artifacts of the -import files and directories into the cache.
+// Artifacts that are already cached are skipped, so an interrupted import can be run again.
+func importArtifacts(ctx context.Context, cfg config.Config, cache *handlers.Cache) error {
+	slog.Info("import started", "sources", cfg.Import, "method", cfg.ImportMethod)
+
+	report, err := cache.Import(ctx, cfg.Import, handlers.ImportMethod(cfg.ImportMethod))
+
+	slog.Info("import completed",
+		"imported", report.Imported,
+		"replaced", report.Replaced,
+		"already_cached", report.Present,
+		"rejected", report.Rejected,
+		"failed", report.Failed,
+		"ignored", report.Ignored,
+		"imported_bytes", report.ImportedBytes,
+	)
+
+	if err != nil {
+		return fmt.Errorf("import interrupted, run it again to resume: %w", err)
+	}
+	if report.Rejected > 0 || report.Failed > 0 {
+		return fmt.Errorf("%d artifacts were rejected and %d failed to import", report.Rejected, report.Failed)
+	}
+	return nil
+}
@@ -16,12 +16,15 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/savalione/go-mirror-zig/handlers"
 	"github.com/savalione/go-mirror-zig/internal/config"
+	"github.com/savalione/go-mirror-zig/internal/metrics"
 	"github.com/savalione/go-mirror-zig/internal/zig"
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -53,9 +56,9 @@ func run() error {
 	var wg sync.WaitGroup
 
 	// Parsing templates that are in cmd/templates
-	tmpl, err := template.ParseFS(content, "templates/*.html")
+	tmpl, err := parseTemplates()
 	if err != nil {
-		return fmt.Errorf("error parsing templates: %w", err)
+		return err
 	}
 
 	cfg, err := config.ParseConfig(os.Args[1:], flag.ExitOnError)
@@ -72,6 +75,15 @@ func run() error {
 		os.Exit(0)
 	}
 
+	if cfg.PrintConfig {
+		data, err := cfg.EffectiveConfig()
+		if err != nil {
+			return fmt.Errorf("failed to print the configuration: %w", err)
+		}
+		os.Stdout.Write(data)
+		os.Exit(0)
+	}
+
 	if cfg.ShowPossibleSize {
 		if err := showPossibleSize(shutdownCtx, cfg); err != nil {
 			return err
@@ -79,122 +91,175 @@ func run() error {
 		os.Exit(0)
 	}
 
-	// A background task to clear zig build artifacts
-	if cfg.ClearBuilds != 0 {
-		clearBuildsTicker := time.NewTicker(time.Duration(cfg.ClearBuilds) * time.Second)
-		defer clearBuildsTicker.Stop()
-
-		go func() {
-			for {
-				select {
-				case <-shutdownCtx.Done():
-					return
-				case <-clearBuildsTicker.C:
-					// Attempt to fetch index.json
-					ctx, cancel := context.WithTimeout(shutdownCtx, 30*time.Second)
-					zr, err := zig.FetchAllReleases(ctx, cfg.UpstreamURL+"/download/index.json")
-					cancel()
-
-					activeMasterBuilds := make(map[string]bool)
-					fetchSuccess := err == nil
-
-					if fetchSuccess {
-						if master, ok := zr["master"]; ok {
-							for _, art := range master.Platforms {
-								filename := path.Base(art.Tarball)
-								activeMasterBuilds[filename] = true
-							}
-						}
-					} else {
-						slog.Warn("failed to fetch index.json for cleanup, falling back to clearing all dev builds", "error", err)
-					}
-
-					buildPath := filepath.Join(cfg.CacheDir, "/builds/")
-					buildFiles, err := os.ReadDir(buildPath)
-					if err != nil {
-						// For some reason the directory hasn't been created yet
-						if !os.IsNotExist(err) {
-							slog.Error("failed to scan cache directory for cleanup", "path", buildPath, "error", err)
-						}
-
-						continue
-					}
-
-					var removedCount int
-					var removedBytes int64
-
-					for _, file := range buildFiles {
-						// Skip empty directories and everything but artifacts
-						if file.IsDir() || !zig.IsZigArtifact(file.Name()) {
-							continue
-						}
-
-						// Current dev build
-						if fetchSuccess && activeMasterBuilds[file.Name()] {
-							continue
-						}
-
-						filePath := filepath.Join(buildPath, file.Name())
-
-						// For stats
-						var fileSize int64
-						if info, err := file.Info(); err == nil {
-							fileSize = info.Size()
-						}
-
-						if err := os.Remove(filePath); err != nil {
-							slog.Error("failed to remove a stale zig artifact", "path", filePath, "error", err)
-						} else {
-							removedCount++
-							removedBytes += fileSize
-						}
-					}
-
-					if removedCount > 0 {
-						slog.Info("stale zig builds cleanup completed", "removed_count", removedCount, "reclaimed_space", removedBytes)
-					}
-				}
-			}
-		}()
-	}
+	m := metrics.New()
 
 	// HTTP and HTTPS Handler setup
 	mux := http.NewServeMux()
-	cache := handlers.NewCache(cfg.UpstreamURL, cfg.CacheDir)
+	// An offline mirror only has the index.json stored in the cache directory.
+	indexURLs := cfg.IndexURLs()
+	if cfg.Offline {
+		indexURLs = nil
+	}
+	// Upstream fills outlive the shutdown signal, the ones still running at the end of the drain are abandoned.
+	fillCtx, abandonFills := context.WithCancel(context.Background())
+	defer abandonFills()
+
+	index := zig.NewIndex(indexURLs, time.Duration(cfg.IndexTTL)*time.Second, filepath.Join(cfg.CacheDir, "download", "index.json"))
+	cacheOptions := handlers.CacheOptions{
+		CacheDir:     cfg.CacheDir,
+		UpstreamHost: cfg.UpstreamURL,
+		Mirrors:      cfg.UpstreamMirrors,
+		Strategy:     handlers.UpstreamStrategy(cfg.UpstreamStrategy),
+		Cooldown:     time.Duration(cfg.UpstreamCooldown) * time.Second,
+		Index:        index,
+		Unlisted:     handlers.UnlistedPolicy(cfg.UnlistedArtifacts),
+		BaseContext:  fillCtx,
+		FillTimeout:  time.Duration(cfg.FillTimeout) * time.Second,
+		MaxFills:     cfg.MaxFills,
+		MaxSize:      cfg.MaxCacheSize,
+		Eviction:     handlers.EvictionPolicy(cfg.EvictionPolicy),
+		Metrics:      m,
+		Offline:      cfg.Offline,
+	}
+	if cfg.VerifySignatures {
+		cacheOptions.PublicKey = &cfg.PublicKey
+	}
+	cache := handlers.NewCache(cacheOptions)
+	cache.EnforceSizeLimit()
 
-	if cfg.ShowIndexPage {
-		if cfg.IndexPage == "" {
-			mux.HandleFunc("/", handlers.RootHandler(tmpl, version))
-		} else {
-			mux.Handle("/", http.FileServer(http.Dir(cfg.IndexPage)))
+	if cfg.Prefetch {
+		// There is nothing to drain without a server.
+		context.AfterFunc(shutdownCtx, abandonFills)
+		if err := prefetch(shutdownCtx, cfg, index, cache); err != nil {
+			return err
+		}
+		os.Exit(0)
+	}
+
+	if len(cfg.Import) > 0 {
+		if err := importArtifacts(shutdownCtx, cfg, cache); err != nil {
+			return err
+		}
+		os.Exit(0)
+	}
+
+	if cfg.Export != "" {
+		if err := exportBundle(shutdownCtx, cfg, cache); err != nil {
+			return err
+		}
+		os.Exit(0)
+	}
+
+	if cfg.Verify {
+		if err := verifyCache(shutdownCtx, cfg, cache); err != nil {
+			return err
 		}
+		os.Exit(0)
+	}
+
+	// Leftovers of a crash are cleaned up before the cache is used.
+	if cfg.Recovery {
+		recoverCache(shutdownCtx, cfg, cache)
+	}
+
+	// The index page, TLS certificate and the intervals of the background tasks are reloaded on SIGHUP.
+	live := newLiveState(cfg, tmpl)
 
-		mux.Handle("/assets/", http.FileServer(http.FS(assets)))
+	// A background task to clear zig build artifacts
+	live.cleanup = startPeriodic(shutdownCtx, time.Duration(cfg.ClearBuilds)*time.Second, false, func(ctx context.Context) {
+		clearStaleBuilds(ctx, live.config(), cache, index, m)
+	})
+
+	// A background task to download new releases and master builds before they are requested
+	live.sync = startPeriodic(shutdownCtx, time.Duration(cfg.SyncInterval)*time.Second, true, newSyncTask(index, cache, live.config))
+
+	// A low-priority background task to detect corrupt cached artifacts
+	live.scrub = startPeriodic(shutdownCtx, time.Duration(cfg.ScrubInterval)*time.Second, false, newScrubTask(cache, live.config))
+
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	defer signal.Stop(reloadSignals)
+	go live.reloadOnSIGHUP(shutdownCtx, reloadSignals)
+
+	mux.Handle("/", live.rootHandler())
+	mux.Handle("/assets/", live.rootHandler())
+
+	// The stored index.json of an offline mirror is never refreshed, its age doesn't matter.
+	indexMaxAge := time.Duration(cfg.IndexMaxAge) * time.Second
+	if cfg.Offline {
+		indexMaxAge = 0
+		slog.Info("offline mode, only cached files are served and no upstream is contacted")
 	}
 
+	// Set once the shutdown signal is received.
+	var draining atomic.Bool
+
+	mux.HandleFunc("/healthz", handlers.HealthHandler())
+	mux.HandleFunc("/readyz", handlers.ReadinessHandler(handlers.ReadinessOptions{
+		CacheDir:     cfg.CacheDir,
+		MinFreeSpace: cfg.MinFreeSpace,
+		Index:        index,
+		IndexMaxAge:  indexMaxAge,
+		Draining:     draining.Load,
+	}))
 	mux.HandleFunc("/{file}", cache.Handler())
 	mux.HandleFunc("/zig/{file}", cache.Handler())
 	mux.HandleFunc("/builds/{file}", cache.Handler())
 	mux.HandleFunc("/download/", cache.Handler())
-	mainHandler := handlers.Middleware(mux)
+	mux.HandleFunc("/download/index.json", handlers.ReleasesHandler(index, cfg.PublicURL))
+
+	var accessLog *handlers.AccessLogger
+	switch cfg.AccessLog {
+	case "":
+	case "-":
+		accessLog = handlers.NewAccessLogger(os.Stdout, handlers.AccessLogFormat(cfg.AccessLogFormat))
+	default:
+		f, err := os.OpenFile(cfg.AccessLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open the access log: %w", err)
+		}
+		defer f.Close()
+		accessLog = handlers.NewAccessLogger(f, handlers.AccessLogFormat(cfg.AccessLogFormat))
+	}
+
+	// The write deadlines are set per response, a server WriteTimeout would cut off large downloads.
+	writeTimeout := time.Duration(cfg.WriteTimeout) * time.Second
+	middlewareOptions := handlers.MiddlewareOptions{
+		AccessLog:      accessLog,
+		TrustedProxies: cfg.TrustedProxies,
+		WriteTimeouts: &handlers.WriteTimeouts{
+			Response:     writeTimeout,
+			DownloadIdle: time.Duration(cfg.DownloadIdleTimeout) * time.Second,
+			Download:     time.Duration(cfg.DownloadTimeout) * time.Second,
+		},
+	}
+	if cfg.RateLimit > 0 || cfg.MaxClientDownloads > 0 || cfg.MaxBytesPerSecond > 0 {
+		middlewareOptions.RateLimiter = handlers.NewRateLimiter(handlers.RateLimitOptions{
+			RequestsPerSecond: cfg.RateLimit,
+			Burst:             cfg.RateLimitBurst,
+			MaxDownloads:      cfg.MaxClientDownloads,
+			BytesPerSecond:    cfg.MaxBytesPerSecond,
+			Metrics:           m,
+		})
+	}
+	mainHandler := handlers.Middleware(mux, middlewareOptions)
 
 	var servers []*http.Server
 
 	if cfg.EnableTLS {
 		tlsConfig := &tls.Config{
-			Certificates:             []tls.Certificate{cfg.KeyPair},
+			GetCertificate:           live.getCertificate,
 			PreferServerCipherSuites: true,
 			MinVersion:               tls.VersionTLS13,
 			CurvePreferences:         []tls.CurveID{tls.CurveP256, tls.X25519},
 		}
 
 		httpsServer := &http.Server{
-			Addr:         cfg.HTTPSAddress(),
-			Handler:      mainHandler,
-			TLSConfig:    tlsConfig,
-			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 10 * time.Second,
-			IdleTimeout:  120 * time.Second,
+			Addr:        cfg.HTTPSAddress(),
+			Handler:     mainHandler,
+			TLSConfig:   tlsConfig,
+			ReadTimeout: 5 * time.Second,
+			IdleTimeout: 120 * time.Second,
 		}
 
 		servers = append(servers, httpsServer)
@@ -207,22 +272,20 @@ func run() error {
 		}
 
 		acmeServer := &http.Server{
-			Addr:         cfg.HTTPSAddress(),
-			Handler:      mainHandler,
-			TLSConfig:    acmeManager.TLSConfig(),
-			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 10 * time.Second,
-			IdleTimeout:  120 * time.Second,
+			Addr:        cfg.HTTPSAddress(),
+			Handler:     mainHandler,
+			TLSConfig:   acmeManager.TLSConfig(),
+			ReadTimeout: 5 * time.Second,
+			IdleTimeout: 120 * time.Second,
 		}
 
 		servers = append(servers, acmeServer)
 	} else {
 		httpServer := &http.Server{
-			Addr:         cfg.HTTPAddress(),
-			Handler:      mainHandler,
-			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 10 * time.Second,
-			IdleTimeout:  120 * time.Second,
+			Addr:        cfg.HTTPAddress(),
+			Handler:     mainHandler,
+			ReadTimeout: 5 * time.Second,
+			IdleTimeout: 120 * time.Second,
 		}
 		servers = append(servers, httpServer)
 	}
@@ -232,33 +295,179 @@ func run() error {
 			Addr:         cfg.HTTPAddress(),
 			Handler:      handlers.RedirectHandler(cfg.TLSPort),
 			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 10 * time.Second,
+			WriteTimeout: writeTimeout,
 			IdleTimeout:  120 * time.Second,
 		}
 		servers = append(servers, redirectServer)
 	}
 
+	if cfg.MetricsAddress != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", m.Registry)
+
+		metricsServer := &http.Server{
+			Addr:         cfg.MetricsAddress,
+			Handler:      metricsMux,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: writeTimeout,
+			IdleTimeout:  120 * time.Second,
+		}
+		servers = append(servers, metricsServer)
+	}
+
+	// Cancelled when the drain starts, the servers stop accepting connections then.
+	listening, stopListening := context.WithCancel(context.Background())
+	defer stopListening()
+	// Cancelled at the end of the drain, the servers close the remaining connections then.
+	drained, closeConnections := context.WithCancel(context.Background())
+	defer closeConnections()
+
 	for _, srv := range servers {
 		wg.Add(1)
-		go startServer(shutdownCtx, &wg, srv)
+		go startServer(listening, &wg, srv, drained)
 	}
 
 	<-shutdownCtx.Done()
-	slog.Info("shutdown signal received. waiting for servers to close.")
-
-	wg.Wait()
+	draining.Store(true)
+	// The drain settings can be changed on reload.
+	delay := time.Duration(live.config().DrainDelay) * time.Second
+	timeout := time.Duration(live.config().DrainTimeout) * time.Second
 
-	// A hard shutdown failsafe
+	// A hard shutdown failsafe in case the drain hangs
 	go func() {
-		time.Sleep(10 * time.Second)
+		time.Sleep(delay + timeout + 10*time.Second)
 		slog.Warn("hard shutdown initiated")
 		os.Exit(1)
 	}()
 
+	// Load balancers see the failing readiness and stop sending new clients before the listeners close.
+	slog.Info("shutdown signal received. /readyz reports draining.", "delay", delay)
+	time.Sleep(delay)
+
+	slog.Info("draining downloads in progress.", "timeout", timeout)
+	stopListening()
+
+	// The servers refuse new connections and wait for the active ones in startServer.
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), timeout)
+	defer drainCancel()
+	report := cache.Drain(drainCtx)
+
+	// Abandoned fills keep what they downloaded as .partial files and remove their temporary files.
+	abandonFills()
+	closeConnections()
+	wg.Wait()
+	cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), abandonedFillsTimeout)
+	defer cleanupCancel()
+	cache.Drain(cleanupCtx)
+
+	logDrainReport(report)
 	return nil
 }
 
-func startServer(ctx context.Context, wg *sync.WaitGroup, srv *http.Server) {
+// Removes cached dev builds that are no longer the current master build.
+// If index.json can't be fetched, all cached dev builds are removed.
+// In offline mode the stored index.json is used, and nothing is removed without it.
+func clearStaleBuilds(ctx context.Context, cfg config.Config, cache *handlers.Cache, index *zig.Index, m *metrics.Metrics) {
+	var zr zig.ZigReleases
+	var err error
+	if cfg.Offline {
+		zr, err = index.Releases(ctx)
+		if err != nil {
+			slog.Warn("no stored index.json for the cleanup of dev builds, skipping it while offline", "error", err)
+			return
+		}
+	} else {
+		// Attempt to fetch index.json
+		fetchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		zr, err = index.Refresh(fetchCtx)
+		cancel()
+	}
+
+	activeMasterBuilds := make(map[string]bool)
+	fetchSuccess := err == nil
+
+	if fetchSuccess {
+		if master, ok := zr["master"]; ok {
+			for _, art := range master.Platforms {
+				filename := path.Base(art.Tarball)
+				activeMasterBuilds[filename] = true
+			}
+		}
+	} else {
+		slog.Warn("failed to fetch index.json for cleanup, falling back to clearing all dev builds", "error", err)
+	}
+
+	// Imported dev builds may not be available anywhere else.
+	importedBuilds, err := cache.ImportedBuilds()
+	if err != nil {
+		slog.Error("failed to read the imported dev builds, skipping the cleanup of dev builds", "error", err)
+		return
+	}
+
+	buildPath := filepath.Join(cfg.CacheDir, "/builds/")
+	buildFiles, err := os.ReadDir(buildPath)
+	if err != nil {
+		// For some reason the directory hasn't been created yet
+		if !os.IsNotExist(err) {
+			slog.Error("failed to scan cache directory for cleanup", "path", buildPath, "error", err)
+		}
+
+		return
+	}
+
+	var removedCount int
+	var removedBytes int64
+
+	for _, file := range buildFiles {
+		// Skip empty directories and everything but artifacts
+		if file.IsDir() || !zig.IsZigArtifact(file.Name()) {
+			continue
+		}
+
+		// Current dev build
+		if fetchSuccess && activeMasterBuilds[file.Name()] {
+			continue
+		}
+
+		if importedBuilds[strings.TrimSuffix(file.Name(), ".minisig")] {
+			continue
+		}
+
+		filePath := filepath.Join(buildPath, file.Name())
+
+		// For stats
+		var fileSize int64
+		if info, err := file.Info(); err == nil {
+			fileSize = info.Size()
+		}
+
+		if err := cache.Remove(filePath); err != nil {
+			slog.Error("failed to remove a stale zig artifact", "path", filePath, "error", err)
+		} else {
+			removedCount++
+			removedBytes += fileSize
+		}
+	}
+
+	m.CleanupRuns.Inc("dev-builds")
+	m.CleanupReclaimedBytes.Add(float64(removedBytes), "dev-builds")
+
+	if removedCount > 0 {
+		slog.Info("stale zig builds cleanup completed", "removed_count", removedCount, "reclaimed_space", removedBytes)
+	}
+}
+
+// Parses the templates that are in cmd/templates.
+func parseTemplates() (*template.Template, error) {
+	tmpl, err := template.ParseFS(content, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing templates: %w", err)
+	}
+	return tmpl, nil
+}
+
+// Serves until ctx is done, then refuses new connections and waits for the active ones until drained is done.
+func startServer(ctx context.Context, wg *sync.WaitGroup, srv *http.Server, drained context.Context) {
 	defer wg.Done()
 
 	isTLS := srv.TLSConfig != nil
@@ -285,12 +494,9 @@ func startServer(ctx context.Context, wg *sync.WaitGroup, srv *http.Server) {
 
 	slog.Info(fmt.Sprintf("shutting down %s server", serverType), "addr", srv.Addr)
 
-	// A context with a timeout for the shutdown
-	shutdownTimeoutCtx, shutdownTimeoutCancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer shutdownTimeoutCancel()
-
-	if err := srv.Shutdown(shutdownTimeoutCtx); err != nil {
-		slog.Error(fmt.Sprintf("failed to shut down %s server gracefully", serverType), "addr", srv.Addr, "error", err)
+	if err := srv.Shutdown(drained); err != nil {
+		// The drain is over, what is still active was abandoned and is reported by the drain.
+		srv.Close()
 	}
 }
 
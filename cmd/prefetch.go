@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/handlers"
+	"github.com/savalione/go-mirror-zig/internal/config"
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// Outcome of downloading a list of artifacts into the cache.
+type fetchSummary struct {
+	artifacts       int
+	downloaded      int
+	alreadyCached   int
+	failed          int
+	skipped         int // not attempted because the context was cancelled
+	downloadedBytes int64
+}
+
+// Downloads all artifacts selected by -versions and -platforms into the cache.
+// Interrupted downloads are resumed by the next run, already cached artifacts are skipped.
+func prefetch(ctx context.Context, cfg config.Config, index *zig.Index, cache *handlers.Cache) error {
+	zr, err := index.Releases(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching the index.json with all Zig releases: %w", err)
+	}
+
+	artifacts := zr.Select(cfg.Versions, cfg.Platforms)
+	if len(artifacts) == 0 {
+		return errors.New("no artifacts match the provided -versions and -platforms")
+	}
+
+	slog.Info("prefetch started", "artifacts", len(artifacts), "jobs", cfg.PrefetchJobs)
+
+	start := time.Now()
+	summary := fetchArtifacts(ctx, cache, artifacts, cfg.PrefetchJobs)
+
+	slog.Info("prefetch completed",
+		"artifacts", summary.artifacts,
+		"downloaded", summary.downloaded,
+		"already_cached", summary.alreadyCached,
+		"failed", summary.failed,
+		"skipped", summary.skipped,
+		"downloaded_bytes", summary.downloadedBytes,
+		"duration", time.Since(start).Round(time.Second).String(),
+	)
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("prefetch interrupted, %d artifacts were not downloaded, run it again to resume: %w", summary.failed+summary.skipped, ctx.Err())
+	}
+	if summary.failed > 0 {
+		return fmt.Errorf("%d of %d artifacts failed to prefetch", summary.failed, summary.artifacts)
+	}
+
+	return nil
+}
+
+// Downloads the artifacts into the cache, at most jobs at a time, and logs the progress.
+func fetchArtifacts(ctx context.Context, cache *handlers.Cache, artifacts []zig.Artifact, jobs int) fetchSummary {
+	summary := fetchSummary{artifacts: len(artifacts)}
+	var completed int
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, jobs)
+
+	for _, artifact := range artifacts {
+		filename := path.Base(artifact.Tarball)
+		size, _ := strconv.ParseInt(artifact.Size, 10, 64)
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			cached, err := cache.Prefetch(ctx, filename)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			completed++
+			progress := fmt.Sprintf("%d/%d", completed, len(artifacts))
+
+			switch {
+			case err != nil:
+				summary.failed++
+				slog.Error("failed to prefetch an artifact", "filename", filename, "progress", progress, "error", err)
+			case cached:
+				summary.alreadyCached++
+				slog.Debug("artifact is already cached", "filename", filename, "progress", progress)
+			default:
+				summary.downloaded++
+				summary.downloadedBytes += size
+				slog.Info("prefetched an artifact", "filename", filename, "progress", progress, "size", size)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	summary.skipped = len(artifacts) - completed
+
+	return summary
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/handlers"
+	"github.com/savalione/go-mirror-zig/internal/config"
+)
+
+// Checks the cache directory before it is used, the server starts even if the check fails.
+func recoverCache(ctx context.Context, cfg config.Config, cache *handlers.Cache) {
+	start := time.Now()
+	report, err := cache.Recover(ctx, handlers.RecoveryOptions{
+		GracePeriod: time.Duration(cfg.RecoveryGracePeriod) * time.Second,
+		Fix:         cfg.RecoveryFix,
+	})
+	if err != nil {
+		slog.Error("failed to check the cache directory", "cache_dir", cfg.CacheDir, "error", err)
+	}
+
+	var removed, moved, misplaced, unknown, failed int
+	for _, finding := range report.Findings {
+		switch {
+		case finding.Action == "failed":
+			failed++
+		case finding.Action == "removed":
+			removed++
+		case finding.Action == "moved":
+			moved++
+		case finding.Kind == handlers.RecoveryMisplaced:
+			misplaced++
+		default:
+			unknown++
+		}
+	}
+
+	slog.Info("cache directory checked",
+		"checked", report.Checked,
+		"removed_temp_files", removed,
+		"moved", moved,
+		"misplaced", misplaced,
+		"unknown", unknown,
+		"failed", failed,
+		"duration", time.Since(start).Round(time.Millisecond).String(),
+	)
+}
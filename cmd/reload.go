@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/handlers"
+	"github.com/savalione/go-mirror-zig/internal/config"
+)
+
+// Settings that are applied on SIGHUP without restarting the listeners,
+// each copies its fields from the reloaded configuration into the running one.
+var reloadableSettings = map[string]func(running *config.Config, reloaded config.Config){
+	"clear-builds-interval": func(running *config.Config, reloaded config.Config) { running.ClearBuilds = reloaded.ClearBuilds },
+	"sync-interval":         func(running *config.Config, reloaded config.Config) { running.SyncInterval = reloaded.SyncInterval },
+	"platforms":             func(running *config.Config, reloaded config.Config) { running.Platforms = reloaded.Platforms },
+	"prefetch-jobs":         func(running *config.Config, reloaded config.Config) { running.PrefetchJobs = reloaded.PrefetchJobs },
+	"scrub-interval":        func(running *config.Config, reloaded config.Config) { running.ScrubInterval = reloaded.ScrubInterval },
+	"scrub-rate":            func(running *config.Config, reloaded config.Config) { running.ScrubRate = reloaded.ScrubRate },
+	"verify-action":         func(running *config.Config, reloaded config.Config) { running.VerifyAction = reloaded.VerifyAction },
+	"verify-report":         func(running *config.Config, reloaded config.Config) { running.VerifyReport = reloaded.VerifyReport },
+	"drain-delay":           func(running *config.Config, reloaded config.Config) { running.DrainDelay = reloaded.DrainDelay },
+	"drain-timeout":         func(running *config.Config, reloaded config.Config) { running.DrainTimeout = reloaded.DrainTimeout },
+	"show-index-page":       func(running *config.Config, reloaded config.Config) { running.ShowIndexPage = reloaded.ShowIndexPage },
+	"index-page":            func(running *config.Config, reloaded config.Config) { running.IndexPage = reloaded.IndexPage },
+	"tls-cert-file":         reloadKeyPair,
+	"tls-key-file":          reloadKeyPair,
+}
+
+// The listeners can't be switched between manual certificates and ACME or plain HTTP, only the certificate is replaced.
+func reloadKeyPair(running *config.Config, reloaded config.Config) {
+	if running.EnableTLS && reloaded.EnableTLS {
+		running.KeyPair = reloaded.KeyPair
+	}
+}
+
+// Holds the parts of the server that change on a reload.
+type liveState struct {
+	// started is the configuration the process started with, parsed the one read by the last reload.
+	started config.Config
+	parsed  config.Config
+
+	cfg  atomic.Pointer[config.Config]
+	cert atomic.Pointer[tls.Certificate]
+	root atomic.Pointer[http.Handler]
+
+	cleanup *periodicTask
+	sync    *periodicTask
+	scrub   *periodicTask
+}
+
+func newLiveState(cfg config.Config, tmpl *template.Template) *liveState {
+	s := &liveState{started: cfg, parsed: cfg}
+	s.apply(cfg, tmpl)
+	return s
+}
+
+func (s *liveState) config() config.Config {
+	return *s.cfg.Load()
+}
+
+// Applies a new configuration, the periodic tasks are updated by reload.
+func (s *liveState) apply(cfg config.Config, tmpl *template.Template) {
+	s.cfg.Store(&cfg)
+
+	if cfg.EnableTLS {
+		s.cert.Store(&cfg.KeyPair)
+	}
+
+	root := rootHandler(cfg, tmpl)
+	s.root.Store(&root)
+}
+
+// getCertificate is the tls.Config.GetCertificate of the manual TLS certificate.
+func (s *liveState) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.Load(), nil
+}
+
+// rootHandler serves the index page of the current configuration.
+func (s *liveState) rootHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		(*s.root.Load()).ServeHTTP(w, r)
+	})
+}
+
+// Returns the handler of the index page (/) and its assets.
+func rootHandler(cfg config.Config, tmpl *template.Template) http.Handler {
+	if !cfg.ShowIndexPage {
+		return http.NotFoundHandler()
+	}
+
+	mux := http.NewServeMux()
+	if cfg.IndexPage == "" {
+		mux.HandleFunc("/", handlers.RootHandler(tmpl, version, cfg.Offline))
+	} else {
+		mux.Handle("/", http.FileServer(http.Dir(cfg.IndexPage)))
+	}
+	mux.Handle("/assets/", http.FileServer(http.FS(assets)))
+	return mux
+}
+
+// Re-reads the configuration on every SIGHUP until ctx is done.
+// An invalid configuration is logged and the current one is kept.
+func (s *liveState) reloadOnSIGHUP(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			s.reload()
+		}
+	}
+}
+
+func (s *liveState) reload() {
+	slog.Info("reloading the configuration")
+
+	cfg, err := config.ParseConfig(os.Args[1:], flag.ContinueOnError)
+	if err != nil {
+		slog.Error("failed to reload the configuration, keeping the current one", "error", err)
+		return
+	}
+
+	tmpl, err := parseTemplates()
+	if err != nil {
+		slog.Error("failed to reload the templates, keeping the current configuration", "error", err)
+		return
+	}
+
+	// Compared to the startup configuration, so that settings changed by an earlier reload are still reported.
+	var restart []string
+	for _, name := range s.started.ChangedSettings(cfg) {
+		if _, ok := reloadableSettings[name]; !ok {
+			restart = append(restart, name)
+		}
+	}
+	if len(restart) > 0 {
+		slog.Warn("some changed settings only apply after a restart", "settings", restart)
+	}
+
+	var changed []string
+	for _, name := range s.parsed.ChangedSettings(cfg) {
+		if _, ok := reloadableSettings[name]; ok {
+			changed = append(changed, name)
+		}
+	}
+	s.parsed = cfg
+
+	// Everything else keeps its running value, the listeners, the cache and the upstreams still use it.
+	running := s.config()
+	for _, copySetting := range reloadableSettings {
+		copySetting(&running, cfg)
+	}
+
+	s.apply(running, tmpl)
+	s.cleanup.setInterval(time.Duration(running.ClearBuilds) * time.Second)
+	s.sync.setInterval(time.Duration(running.SyncInterval) * time.Second)
+	s.scrub.setInterval(time.Duration(running.ScrubInterval) * time.Second)
+
+	slog.Info("configuration reloaded", "changed_settings", changed)
+}
+
+// periodicTask runs a function at an interval that can be changed while it is running.
+type periodicTask struct {
+	intervals chan time.Duration
+}
+
+// Starts running fn every interval until ctx is done, a zero interval pauses the task.
+// If runAtStart is set, fn also runs right away.
+func startPeriodic(ctx context.Context, interval time.Duration, runAtStart bool, fn func(context.Context)) *periodicTask {
+	t := &periodicTask{intervals: make(chan time.Duration, 1)}
+
+	go func() {
+		var ticker *time.Ticker
+		var tick <-chan time.Time
+
+		setInterval := func(d time.Duration) {
+			if ticker != nil {
+				ticker.Stop()
+				ticker, tick = nil, nil
+			}
+			if d > 0 {
+				ticker = time.NewTicker(d)
+				tick = ticker.C
+			}
+		}
+		setInterval(interval)
+		defer setInterval(0)
+
+		if runAtStart && interval > 0 {
+			fn(ctx)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case d := <-t.intervals:
+				if d != interval {
+					interval = d
+					setInterval(d)
+				}
+			case <-tick:
+				fn(ctx)
+			}
+		}
+	}()
+
+	return t
+}
+
+// Changes the interval of the task, a changed interval starts counting from now.
+func (t *periodicTask) setInterval(d time.Duration) {
+	// Only the latest interval matters.
+	select {
+	case <-t.intervals:
+	default:
+	}
+	t.intervals <- d
+}
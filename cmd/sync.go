@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/handlers"
+	"github.com/savalione/go-mirror-zig/internal/config"
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// Returns the task that polls index.json every -sync-interval seconds and downloads the -platforms artifacts
+// of newly published releases and of the current master build before anyone asks for them.
+// Every poll logs what changed since the previous one. currentConfig returns the reloaded configuration.
+func newSyncTask(index *zig.Index, cache *handlers.Cache, currentConfig func() config.Config) func(context.Context) {
+	var previous zig.ZigReleases
+	var started bool
+
+	return func(ctx context.Context) {
+		if !started {
+			started = true
+
+			// The index.json stored by the previous run of the server is the baseline,
+			// so releases published while the server was down are synced too.
+			var err error
+			previous, err = index.Releases(ctx)
+			if err != nil {
+				slog.Warn("failed to fetch index.json for the upstream sync, the first poll is used as the baseline", "error", err)
+			}
+		}
+
+		previous = syncOnce(ctx, currentConfig(), index, cache, previous)
+	}
+}
+
+// Runs a single poll and returns the releases to compare the next poll against.
+func syncOnce(ctx context.Context, cfg config.Config, index *zig.Index, cache *handlers.Cache, previous zig.ZigReleases) zig.ZigReleases {
+	// Nothing can be downloaded while offline.
+	if cfg.Offline {
+		return previous
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	current, err := index.Refresh(fetchCtx)
+	cancel()
+	if err != nil {
+		slog.Warn("failed to fetch index.json for the upstream sync", "error", err)
+		return previous
+	}
+
+	versions := []string{"master"}
+	if previous != nil {
+		changes := current.Diff(previous)
+		if !changes.Empty() {
+			slog.Info("upstream index.json changed",
+				"new_versions", changes.NewVersions,
+				"added_artifacts", changes.AddedArtifacts,
+				"changed_artifacts", changes.ChangedArtifacts,
+				"removed_artifacts", changes.RemovedArtifacts,
+			)
+		}
+
+		for _, version := range changes.NewVersions {
+			if !slices.Contains(versions, version) {
+				versions = append(versions, version)
+			}
+		}
+	}
+
+	// Already cached artifacts (e.g. an unchanged master build) are skipped right away.
+	artifacts := current.Select(versions, cfg.Platforms)
+	if len(artifacts) == 0 {
+		return current
+	}
+
+	start := time.Now()
+	summary := fetchArtifacts(ctx, cache, artifacts, cfg.PrefetchJobs)
+
+	if summary.downloaded > 0 || summary.failed > 0 {
+		slog.Info("upstream sync completed",
+			"versions", versions,
+			"downloaded", summary.downloaded,
+			"already_cached", summary.alreadyCached,
+			"failed", summary.failed,
+			"downloaded_bytes", summary.downloadedBytes,
+			"duration", time.Since(start).Round(time.Second).String(),
+		)
+	}
+
+	// Keep the old baseline so that the failed artifacts of new releases are retried by the next poll.
+	if summary.failed > 0 || summary.skipped > 0 {
+		return previous
+	}
+
+	return current
+}
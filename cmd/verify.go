@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/handlers"
+	"github.com/savalione/go-mirror-zig/internal/atomicfile"
+	"github.com/savalione/go-mirror-zig/internal/config"
+)
+
+// Verifies all cached artifacts once, writes the report to -verify-report and
+// fails if an artifact is corrupt or couldn't be read.
+func verifyCache(ctx context.Context, cfg config.Config, cache *handlers.Cache) error {
+	slog.Info("cache verification started", "cache_dir", cfg.CacheDir, "action", cfg.VerifyAction)
+
+	report, err := cache.Scrub(ctx, handlers.ScrubOptions{Action: handlers.ScrubAction(cfg.VerifyAction)})
+	logScrubReport(report)
+
+	if writeErr := writeScrubReport(cfg.VerifyReport, report); writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cache verification interrupted after %d artifacts: %w", report.Checked, err)
+	}
+	if report.Corrupt > 0 || report.Errors > 0 {
+		return fmt.Errorf("cache verification failed: %d corrupt and %d unreadable artifacts", report.Corrupt, report.Errors)
+	}
+	return nil
+}
+
+// Returns the task that verifies all cached artifacts every -scrub-interval seconds at the -scrub-rate.
+// currentConfig returns the reloaded configuration.
+func newScrubTask(cache *handlers.Cache, currentConfig func() config.Config) func(context.Context) {
+	return func(ctx context.Context) {
+		cfg := currentConfig()
+
+		report, err := cache.Scrub(ctx, handlers.ScrubOptions{
+			Action:         handlers.ScrubAction(cfg.VerifyAction),
+			BytesPerSecond: cfg.ScrubRate,
+		})
+		if err != nil {
+			slog.Warn("background cache verification interrupted", "checked", report.Checked, "error", err)
+			return
+		}
+		logScrubReport(report)
+
+		// The server only writes reports to files, so that they don't mix with the logs.
+		if cfg.VerifyReport != "-" {
+			if err := writeScrubReport(cfg.VerifyReport, report); err != nil {
+				slog.Error("failed to write the cache verification report", "error", err)
+			}
+		}
+	}
+}
+
+func logScrubReport(report handlers.ScrubReport) {
+	slog.Info("cache verification completed",
+		"checked", report.Checked,
+		"ok", report.OK,
+		"corrupt", report.Corrupt,
+		"unverified", report.Unverified,
+		"errors", report.Errors,
+		"duration", report.Finished.Sub(report.Started).Round(time.Millisecond).String(),
+	)
+}
+
+// Writes the report as JSON to a file, which is replaced atomically, or to stdout for "-".
+func writeScrubReport(path string, report handlers.ScrubReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := atomicfile.WriteFile(path, data); err != nil {
+		return fmt.Errorf("failed to write the verification report: %w", err)
+	}
+	return nil
+}
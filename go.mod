@@ -2,7 +2,10 @@ module github.com/savalione/go-mirror-zig
 
 go 1.26.5
 
-require golang.org/x/crypto v0.54.0
+require (
+	golang.org/x/crypto v0.54.0
+	golang.org/x/sys v0.47.0
+)
 
 require (
 	golang.org/x/net v0.57.0 // indirect
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the output format of the access log.
+type AccessLogFormat string
+
+const (
+	// AccessLogJSON writes one JSON object per request.
+	AccessLogJSON AccessLogFormat = "json"
+	// AccessLogLogfmt writes one line of key=value pairs per request.
+	AccessLogLogfmt AccessLogFormat = "logfmt"
+	// AccessLogCombined writes the Apache combined log format, it doesn't include the cache status and the duration.
+	AccessLogCombined AccessLogFormat = "combined"
+)
+
+// AccessLogger writes a line for every handled request.
+type AccessLogger struct {
+	format AccessLogFormat
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Creates an access logger writing to w in the provided format.
+func NewAccessLogger(w io.Writer, format AccessLogFormat) *AccessLogger {
+	return &AccessLogger{w: w, format: format}
+}
+
+// A single access log entry.
+type accessLogEntry struct {
+	Time        time.Time `json:"time"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Protocol    string    `json:"protocol"`
+	Status      int       `json:"status"`
+	Bytes       int64     `json:"bytes"`
+	DurationMs  float64   `json:"duration_ms"`
+	RemoteIP    string    `json:"remote_ip"`
+	Source      string    `json:"source,omitempty"`
+	UserAgent   string    `json:"user_agent"`
+	Referer     string    `json:"referer,omitempty"`
+	CacheStatus string    `json:"cache,omitempty"`
+
+	requestURI string
+}
+
+func (l *AccessLogger) log(e accessLogEntry) {
+	var line []byte
+
+	switch l.format {
+	case AccessLogLogfmt:
+		line = e.logfmt()
+	case AccessLogCombined:
+		line = e.combined()
+	default:
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+func (e accessLogEntry) logfmt() []byte {
+	var b strings.Builder
+
+	pairs := []struct{ key, value string }{
+		{"time", e.Time.Format(time.RFC3339Nano)},
+		{"method", e.Method},
+		{"path", e.Path},
+		{"protocol", e.Protocol},
+		{"status", strconv.Itoa(e.Status)},
+		{"bytes", strconv.FormatInt(e.Bytes, 10)},
+		{"duration_ms", strconv.FormatFloat(e.DurationMs, 'f', 3, 64)},
+		{"remote_ip", e.RemoteIP},
+		{"source", e.Source},
+		{"user_agent", e.UserAgent},
+		{"referer", e.Referer},
+		{"cache", e.CacheStatus},
+	}
+
+	for i, pair := range pairs {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(pair.key)
+		b.WriteByte('=')
+		if pair.value == "" || strings.ContainsAny(pair.value, " =\"\\\t\n") {
+			b.WriteString(strconv.Quote(pair.value))
+		} else {
+			b.WriteString(pair.value)
+		}
+	}
+	b.WriteByte('\n')
+
+	return []byte(b.String())
+}
+
+func (e accessLogEntry) combined() []byte {
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+
+	referer := e.Referer
+	if referer == "" {
+		referer = "-"
+	}
+
+	return fmt.Appendf(nil, "%s - - [%s] \"%s %s %s\" %d %s %s %s\n",
+		e.RemoteIP,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.requestURI, e.Protocol,
+		e.Status,
+		size,
+		strconv.Quote(referer),
+		strconv.Quote(e.UserAgent),
+	)
+}
+
+// Per-request details set by the handlers and read by the access log.
+type requestInfo struct {
+	remoteIP string
+
+	mu          sync.Mutex
+	cacheStatus string
+}
+
+type requestInfoKey struct{}
+
+func withRequestInfo(r *http.Request, remoteIP string) (*http.Request, *requestInfo) {
+	info := &requestInfo{remoteIP: remoteIP}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// Records whether the artifact was served from the cache (HIT), downloaded for the request (MISS),
+// or shared with a download started by another request (COALESCED).
+func setCacheStatus(r *http.Request, status string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.cacheStatus = status
+		info.mu.Unlock()
+	}
+}
+
+func (info *requestInfo) getCacheStatus() string {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	return info.cacheStatus
+}
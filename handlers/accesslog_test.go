@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	t.Parallel()
+
+	entry := accessLogEntry{
+		Time:        time.Date(2025, time.June, 1, 12, 30, 0, 0, time.UTC),
+		Method:      "GET",
+		Path:        "/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz",
+		Protocol:    "HTTP/1.1",
+		Status:      http.StatusOK,
+		Bytes:       1024,
+		DurationMs:  12.5,
+		RemoteIP:    "1.2.3.4",
+		Source:      "setup-zig",
+		UserAgent:   "curl/8.0",
+		CacheStatus: "HIT",
+		requestURI:  "/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz?source=setup-zig",
+	}
+
+	tests := []struct {
+		format AccessLogFormat
+		want   string
+	}{
+		{
+			AccessLogJSON,
+			`{"time":"2025-06-01T12:30:00Z","method":"GET","path":"/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz","protocol":"HTTP/1.1","status":200,"bytes":1024,"duration_ms":12.5,"remote_ip":"1.2.3.4","source":"setup-zig","user_agent":"curl/8.0","cache":"HIT"}` + "\n",
+		},
+		{
+			AccessLogLogfmt,
+			`time=2025-06-01T12:30:00Z method=GET path=/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz protocol=HTTP/1.1 status=200 bytes=1024 duration_ms=12.500 remote_ip=1.2.3.4 source=setup-zig user_agent=curl/8.0 referer="" cache=HIT` + "\n",
+		},
+		{
+			AccessLogCombined,
+			`1.2.3.4 - - [01/Jun/2025:12:30:00 +0000] "GET /download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz?source=setup-zig HTTP/1.1" 200 1024 "-" "curl/8.0"` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			NewAccessLogger(&buf, tt.format).log(entry)
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLogCacheStatus(t *testing.T) {
+	t.Parallel()
+
+	content := "zig tarball"
+	upstream := newTestUpstream(t, content, content)
+
+	cache := NewCache(CacheOptions{
+		UpstreamHost: upstream.URL,
+		CacheDir:     t.TempDir(),
+		Index:        zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, ""),
+		Unlisted:     UnlistedReject,
+	})
+
+	var buf bytes.Buffer
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download/", cache.Handler())
+	handler := Middleware(mux, MiddlewareOptions{AccessLog: NewAccessLogger(&buf, AccessLogJSON)})
+
+	path := "/download/0.14.1/" + testArtifact + "?source=test"
+	for range 2 {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("User-Agent", "zig-test")
+		handler.ServeHTTP(rr, req)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/download/0.14.1/"+testArtifact+".unknown", nil))
+
+	want := []struct {
+		status int
+		bytes  int64
+		cache  string
+	}{
+		{http.StatusOK, int64(len(content)), "MISS"},
+		{http.StatusOK, int64(len(content)), "HIT"},
+		{http.StatusBadRequest, -1, ""},
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %v access log lines, want %v", len(lines), len(want))
+	}
+
+	for i, line := range lines {
+		var got accessLogEntry
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("failed to parse access log line %q: %v", line, err)
+		}
+
+		if got.Status != want[i].status {
+			t.Errorf("line %v: got status %v, want %v", i, got.Status, want[i].status)
+		}
+		if want[i].bytes >= 0 && got.Bytes != want[i].bytes {
+			t.Errorf("line %v: got %v bytes, want %v", i, got.Bytes, want[i].bytes)
+		}
+		if got.CacheStatus != want[i].cache {
+			t.Errorf("line %v: got cache status %q, want %q", i, got.CacheStatus, want[i].cache)
+		}
+	}
+
+	var first accessLogEntry
+	json.Unmarshal([]byte(lines[0]), &first)
+	if first.Source != "test" || first.UserAgent != "zig-test" || first.RemoteIP != "192.0.2.1" {
+		t.Errorf("got source %q, user agent %q and remote IP %q, want %q, %q and %q", first.Source, first.UserAgent, first.RemoteIP, "test", "zig-test", "192.0.2.1")
+	}
+}
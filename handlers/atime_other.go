@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || dragonfly || netbsd || openbsd || windows)
+
+package handlers
+
+import (
+	"io/fs"
+	"time"
+)
+
+// The access time of files can't be determined on this platform.
+func accessTime(info fs.FileInfo) time.Time {
+	return time.Time{}
+}
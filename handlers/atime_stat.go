@@ -0,0 +1,18 @@
+//go:build linux || openbsd || dragonfly
+
+package handlers
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// Returns the last access time of a file, the zero time if it is unknown.
+func accessTime(info fs.FileInfo) time.Time {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(st.Atim.Unix())
+}
@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// Returns the last access time of a file, the zero time if it is unknown.
+func accessTime(info fs.FileInfo) time.Time {
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(0, attrs.LastAccessTime.Nanoseconds())
+}
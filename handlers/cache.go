@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -9,34 +11,135 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/savalione/go-mirror-zig/internal/atomicfile"
+	"github.com/savalione/go-mirror-zig/internal/metrics"
 	"github.com/savalione/go-mirror-zig/internal/zig"
 )
 
+// UnlistedPolicy decides what happens to artifacts that are not listed in the upstream index.json.
+type UnlistedPolicy string
+
+const (
+	// UnlistedAccept caches unlisted artifacts without checksum verification and logs a warning.
+	UnlistedAccept UnlistedPolicy = "accept"
+	// UnlistedReject refuses to download unlisted artifacts.
+	UnlistedReject UnlistedPolicy = "reject"
+)
+
+// CacheOptions holds the settings used to build a Cache.
+type CacheOptions struct {
+	CacheDir string
+	// UpstreamHost is the canonical upstream (ziglang.org).
+	UpstreamHost string
+	// Mirrors are the fallback upstreams, tried after UpstreamHost unless Strategy says otherwise.
+	Mirrors []string
+	// Strategy decides the order in which the upstreams are tried.
+	Strategy UpstreamStrategy
+	// Cooldown is how long an upstream that keeps failing is skipped. Defaults to 5 minutes.
+	Cooldown time.Duration
+	// Index provides the upstream index.json used to verify downloaded artifacts.
+	Index *zig.Index
+	// Unlisted is the policy for artifacts that are not listed in Index.
+	Unlisted UnlistedPolicy
+	// PublicKey verifies the minisign signature of every downloaded artifact. Nil disables the verification.
+	PublicKey *zig.PublicKey
+	// BaseContext is the parent context of all upstream fills, cancelling it (the end of the shutdown drain) aborts them.
+	// Defaults to context.Background().
+	BaseContext context.Context
+	// FillTimeout limits the duration of a single upstream fill. Defaults to 30 minutes.
+	FillTimeout time.Duration
+	// MaxSize is the size limit of the cache in bytes, zero means unlimited.
+	MaxSize int64
+	// Eviction decides which artifacts are removed first once the cache exceeds MaxSize.
+	Eviction EvictionPolicy
+	// MaxFills is the number of concurrent upstream fills, zero means unlimited.
+	// Further fills wait in a queue, fills clients are waiting for go before prefetch and sync.
+	MaxFills int
+	// Metrics records the cache activity. Defaults to a new set of metrics that is not exposed anywhere.
+	Metrics *metrics.Metrics
+	// Offline serves only cached files and never contacts an upstream, misses are answered right away.
+	Offline bool
+}
+
 // Cache holds the dependencies for the cache handler, making it more testable and organized.
 type Cache struct {
-	upstreamHost string
-	cacheDir     string
-	client       *http.Client // Use a custom client for timeouts.
-	fileLocks    sync.Map     // Safely stores locks for in-flight downloads.
+	upstreams   *upstreamPool
+	cacheDir    string
+	index       *zig.Index
+	unlisted    UnlistedPolicy
+	publicKey   *zig.PublicKey
+	baseCtx     context.Context
+	fillTimeout time.Duration
+	evictor     *evictor
+	scheduler   *fillScheduler
+	metrics     *metrics.Metrics
+	offline     bool
+	client      *http.Client // Use a custom client for timeouts.
+
+	fillsMu sync.Mutex
+	fills   map[string]*fill // In-flight downloads, keyed by filename.
+
+	// Artifacts being sent to clients, and the number of transfers ever started.
+	transfers        atomic.Int64
+	transfersStarted atomic.Int64
 }
 
 // NewCache creates a new Cache handler dependency object.
-func NewCache(upstreamHost, cacheDir string) *Cache {
-	return &Cache{
-		upstreamHost: upstreamHost,
-		cacheDir:     cacheDir,
+func NewCache(opts CacheOptions) *Cache {
+	if opts.BaseContext == nil {
+		opts.BaseContext = context.Background()
+	}
+	if opts.FillTimeout <= 0 {
+		opts.FillTimeout = 30 * time.Minute
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 5 * time.Minute
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.New()
+	}
+
+	c := &Cache{
+		upstreams:   newUpstreamPool(opts.UpstreamHost, opts.Mirrors, opts.Strategy, opts.Cooldown),
+		cacheDir:    opts.CacheDir,
+		index:       opts.Index,
+		unlisted:    opts.Unlisted,
+		publicKey:   opts.PublicKey,
+		baseCtx:     opts.BaseContext,
+		fillTimeout: opts.FillTimeout,
+		evictor:     newEvictor(opts.CacheDir, opts.MaxSize, opts.Eviction),
+		scheduler:   newFillScheduler(opts.MaxFills),
+		metrics:     opts.Metrics,
+		offline:     opts.Offline,
 		client: &http.Client{
-			Timeout: 30 * time.Minute, // Timeout for the entire download.
+			// Every fill has its own deadline, see FillTimeout.
 			Transport: &http.Transport{
 				IdleConnTimeout: 90 * time.Second,
 			},
 		},
-		fileLocks: sync.Map{},
+		fills: make(map[string]*fill),
 	}
+
+	c.metrics.Registry.GaugeFunc("zigmirror_cache_size_bytes", "Size of the cached artifacts.", func() float64 {
+		return float64(c.evictor.size.Load())
+	})
+	c.metrics.Registry.GaugeFunc("zigmirror_upstream_fills_active", "Upstream downloads in progress.", func() float64 {
+		active, _ := c.scheduler.stats()
+		return float64(active)
+	})
+	c.metrics.Registry.GaugeFunc("zigmirror_upstream_fill_queue_depth", "Upstream downloads waiting for a free slot.", func() float64 {
+		_, queued := c.scheduler.stats()
+		return float64(queued)
+	})
+
+	return c
 }
 
 // Handler returns the http.HandlerFunc for caching.
@@ -60,53 +163,79 @@ func (c *Cache) Handler() http.HandlerFunc {
 
 		zigSubmatches := zig.ArtifactSubmatches(filename)
 
+		// Transfers are awaited by Drain.
+		c.transfersStarted.Add(1)
+		c.transfers.Add(1)
+		defer c.transfers.Add(-1)
+
 		// Full path to the file.
-		var fileFullPath string
-		if strings.Contains(zigSubmatches[1], "-dev") {
-			fileFullPath = filepath.Join(c.cacheDir, "builds", filename)
-		} else {
-			fileFullPath = filepath.Join(c.cacheDir, "download", zigSubmatches[1], filename)
-		}
+		fileFullPath := filepath.Join(c.destinationDir(zigSubmatches[1]), filename)
+
+		// The file must not be evicted while it is served.
+		unpin := c.evictor.pin(fileFullPath)
+		defer unpin()
+
+		// Count the bytes sent to the client.
+		cw := &countingWriter{ResponseWriter: w}
+		w = cw
 
 		if fileExists(fileFullPath) {
+			c.recordRequest(r, "hit", filename)
+			c.evictor.touch(fileFullPath)
 			serveFile(w, r, fileFullPath, logger)
+			c.metrics.ServedBytes.Add(float64(cw.written), "cache")
 			return
 		}
 
-		// Lock and download. The file is not in the cache.
-		// The file needs to be downloaded. Lock to prevent multiple concurrent
-		// downloads for the same file.
-		mu, _ := c.fileLocks.LoadOrStore(filename, &sync.Mutex{})
-		fileMutex := mu.(*sync.Mutex)
-
-		fileMutex.Lock()
-		defer func() {
-			fileMutex.Unlock()
-			c.fileLocks.Delete(filename)
-		}()
+		if c.offline {
+			c.recordRequest(r, "miss", filename)
+			c.writeOfflineMiss(w, r, logger, filename)
+			return
+		}
 
-		// Double-check if another request downloaded the file while we were waiting for the lock.
-		if fileExists(fileFullPath) {
-			logger.Info("file was cached by another request while waiting for lock")
+		// The file is not in the cache. Join the in-flight download of the file,
+		// or start a new one, so that concurrent requests share a single upstream fetch.
+		f, started := c.startFill(logger, filename, zigSubmatches[1], priorityInteractive)
+		if f == nil {
+			logger.Info("file was cached by another request in the meantime")
+			c.recordRequest(r, "hit", filename)
+			c.evictor.touch(fileFullPath)
 			serveFile(w, r, fileFullPath, logger)
+			c.metrics.ServedBytes.Add(float64(cw.written), "cache")
 			return
 		}
+		if started {
+			c.recordRequest(r, "miss", filename)
+		} else {
+			logger.Info("joining an in-flight download")
+			c.recordRequest(r, "coalesced", filename)
+			// A queued prefetch or sync of the file is now awaited by a client.
+			c.scheduler.promote(f.ticket, priorityInteractive)
+		}
+
+		// The fill is owned by the cache, the client only follows it.
+		// A client that goes away does not affect the fill or the other clients.
+		f.addClient()
+		defer func() {
+			if f.removeClient() == 0 && !f.state().done {
+				logger.Info("all clients detached, the download continues in the background")
+			}
+		}()
 
-		// Fetch from upstream
-		logger.Info("file not in cache, starting download")
-		if err := c.fetchAndCacheFile(r.Context(), logger, filename, zigSubmatches[1]); err != nil {
-			if errors.Is(err, errUpstreamNotFound) {
-				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-			} else if errors.Is(err, errUpstreamUnavailable) {
-				http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
-			} else {
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		if err := f.waitStarted(r.Context()); err != nil {
+			if errors.Is(err, errFillElsewhere) && fileExists(fileFullPath) {
+				logger.Info("file was cached by another process in the meantime")
+				c.evictor.touch(fileFullPath)
+				serveFile(w, r, fileFullPath, logger)
+				c.metrics.ServedBytes.Add(float64(cw.written), "cache")
+				return
 			}
+			writeFillError(w, err)
 			return
 		}
 
-		// Serve the newly cached file.
-		serveFile(w, r, fileFullPath, logger)
+		defer func() { c.metrics.ServedBytes.Add(float64(cw.written), "fill") }()
+		serveFill(w, r, f, logger)
 	}
 }
 
@@ -114,98 +243,448 @@ func (c *Cache) Handler() http.HandlerFunc {
 var (
 	errUpstreamNotFound    = errors.New("file not found on upstream")
 	errUpstreamUnavailable = errors.New("upstream server returned non-OK status")
+	errUnlistedArtifact    = errors.New("artifact is not listed in the upstream index")
+	errChecksumMismatch    = errors.New("artifact checksum does not match the upstream index")
+	errSignatureInvalid    = errors.New("artifact signature is missing or invalid")
+	errOffline             = errors.New("the mirror is offline")
+	errDraining            = errors.New("the mirror is shutting down")
+	errFillElsewhere       = errors.New("the file was cached by another process using the cache directory")
 )
 
-func (c *Cache) fetchAndCacheFile(ctx context.Context, logger *slog.Logger, filename, version string) error {
-	// Determine upstream URL
-	var sourceURL string
-	if strings.Contains(version, "-dev") {
-		sourceURL = fmt.Sprintf("%s/builds/%s", c.upstreamHost, filename)
-	} else {
-		sourceURL = fmt.Sprintf("%s/download/%s/%s", c.upstreamHost, version, filename)
+// Prefetch downloads a file into the cache the same way a client request does, and waits until it is cached.
+// It reports whether the file was already cached. An in-flight download of the file is joined instead of started again.
+func (c *Cache) Prefetch(ctx context.Context, filename string) (alreadyCached bool, err error) {
+	if !zig.IsZigArtifact(filename) {
+		return false, fmt.Errorf("invalid filename format: %s", filename)
 	}
 
-	// Where to put the file
-	var pathDestination string
-	if strings.Contains(version, "-dev") {
-		pathDestination = filepath.Join(c.cacheDir, "builds")
+	version := zig.ArtifactSubmatches(filename)[1]
+	fileFullPath := filepath.Join(c.destinationDir(version), filename)
+
+	if fileExists(fileFullPath) {
+		return true, nil
+	}
+
+	if c.offline {
+		return false, errOffline
+	}
+
+	logger := slog.With("filename", filename, "source", "prefetch")
+
+	f, _ := c.startFill(logger, filename, version, priorityBackground)
+	if f == nil {
+		return true, nil
+	}
+
+	err = f.wait(ctx)
+	if errors.Is(err, errFillElsewhere) && fileExists(fileFullPath) {
+		return true, nil
+	}
+	return false, err
+}
+
+// EnforceSizeLimit evicts cached artifacts until the cache fits into its size limit.
+// It is called after every fill, calling it at startup applies a lowered limit right away.
+func (c *Cache) EnforceSizeLimit() {
+	c.enforceSizeLimit(slog.Default())
+}
+
+func (c *Cache) enforceSizeLimit(logger *slog.Logger) {
+	if reclaimed := c.evictor.enforce(logger); reclaimed > 0 {
+		c.metrics.CleanupRuns.Inc("eviction")
+		c.metrics.CleanupReclaimedBytes.Add(float64(reclaimed), "eviction")
+	}
+}
+
+// Remove removes a cached artifact or signature, e.g. a stale dev build.
+func (c *Cache) Remove(path string) error {
+	return c.evictor.track(func() error { return os.Remove(path) }, path)
+}
+
+// Answers a request for a file that is not cached without contacting an upstream.
+// Files listed in the stored index.json exist upstream and are only unavailable (503), other files are unknown (404).
+func (c *Cache) writeOfflineMiss(w http.ResponseWriter, r *http.Request, logger *slog.Logger, filename string) {
+	listed := false
+	if c.index != nil {
+		// Signatures are listed through their artifacts.
+		_, listed, _ = c.index.Lookup(r.Context(), strings.TrimSuffix(filename, ".minisig"))
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if listed {
+		logger.Info("file not in cache, the mirror is offline")
+		http.Error(w, filename+" is not cached and this mirror is offline, it can't be downloaded from upstream right now.", http.StatusServiceUnavailable)
+		return
+	}
+
+	logger.Info("unknown file not in cache, the mirror is offline")
+	http.Error(w, filename+" is not cached and not listed in the index.json of this offline mirror.", http.StatusNotFound)
+}
+
+// Maps a failed fill to the response status.
+func writeFillError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUpstreamNotFound) || errors.Is(err, errUnlistedArtifact) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	} else if errors.Is(err, errUpstreamUnavailable) || errors.Is(err, errChecksumMismatch) || errors.Is(err, errSignatureInvalid) {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	} else if errors.Is(err, errDraining) || errors.Is(err, errFillElsewhere) {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
 	} else {
-		pathDestination = filepath.Join(c.cacheDir, "download", version)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
+}
 
-	logger = logger.With("source_url", sourceURL)
-	logger.Info("fetching file from upstream")
+// Returns the in-flight fill of the file, starting a new one if there is none.
+// started reports whether the fill was started by this call.
+// A nil fill means the file has been cached since the caller last checked.
+//
+// Fills run in the background, detached from the request that started them.
+// Only the base context of the cache (server shutdown) or the fill timeout cancel them,
+// and a drain cancels the fills that are still queued.
+// The fill waits for a free slot of the scheduler with the provided priority first.
+func (c *Cache) startFill(logger *slog.Logger, filename, version string, priority fillPriority) (f *fill, started bool) {
+	lockStart := time.Now()
+	c.fillsMu.Lock()
+	defer c.fillsMu.Unlock()
+	c.metrics.FillLockWait.Observe(time.Since(lockStart).Seconds())
+
+	if f, ok := c.fills[filename]; ok {
+		return f, false
+	}
+
+	finalPath := filepath.Join(c.destinationDir(version), filename)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	// Double-check if another request downloaded the file before we took the lock.
+	if fileExists(finalPath) {
+		return nil, false
+	}
+
+	f = newFill(filename, version, finalPath)
+	f.ticket = c.scheduler.newTicket(priority)
+	c.fills[filename] = f
+
+	logger.Info("file not in cache, starting download")
+
+	go func() {
+		err := c.scheduler.acquire(c.baseCtx, f.ticket, func(depth int) {
+			logger.Info("too many downloads in progress, the download is queued", "priority", priority, "queue_depth", depth)
+		})
+		if err != nil {
+			c.fillsMu.Lock()
+			delete(c.fills, filename)
+			c.fillsMu.Unlock()
+
+			f.finish(err)
+			return
+		}
+		defer c.scheduler.release()
+
+		// The fill timeout starts once the download is allowed to start.
+		ctx, cancel := context.WithTimeout(c.baseCtx, c.fillTimeout)
+		defer cancel()
+
+		fillStart := time.Now()
+		err = c.fetchAndCacheFile(ctx, logger, f)
+
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		c.metrics.FillDuration.Observe(time.Since(fillStart).Seconds(), result)
+
+		// The file is committed (or abandoned) before the fill is forgotten,
+		// so new requests either find the cached file or start over.
+		c.fillsMu.Lock()
+		delete(c.fills, filename)
+		c.fillsMu.Unlock()
+
+		f.finish(err)
+
+		if err == nil {
+			c.enforceSizeLimit(logger)
+		}
+	}()
+
+	return f, true
+}
+
+func (c *Cache) fetchAndCacheFile(ctx context.Context, logger *slog.Logger, f *fill) error {
+	// The partial download and its validators belong to the holder of the lock.
+	lock, err := c.lockPartial(ctx, logger, f.filename)
 	if err != nil {
-		logger.Error("failed to create upstream request", "error", err)
+		logger.Error("failed to lock the partial download", "error", err)
 		return err
 	}
+	defer unlockFile(lock)
+
+	// Cached by another process since the fill started, e.g. while waiting for the lock.
+	// The waiting clients are served the cached file.
+	if fileExists(f.finalPath) {
+		return errFillElsewhere
+	}
 
-	resp, err := c.client.Do(req)
+	shasum, err := c.expectedShasum(ctx, logger, f.filename)
 	if err != nil {
-		logger.Error("failed to fetch file from upstream", "error", err)
-		return errUpstreamUnavailable
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		logger.Warn("file not found on upstream")
-		return errUpstreamNotFound
+	candidates := c.upstreams.candidates()
+
+	// An interrupted download can only be resumed from the upstream it came from.
+	if partialURL := c.partialURL(f.filename); partialURL != "" {
+		if i := slices.IndexFunc(candidates, func(u *upstream) bool {
+			return u.artifactURL(f.filename, f.version) == partialURL
+		}); i > 0 {
+			candidates = append([]*upstream{candidates[i]}, slices.Delete(candidates, i, i+1)...)
+		}
 	}
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("upstream server returned non-OK status", "status_code", resp.StatusCode)
-		return errUpstreamUnavailable
+
+	var lastErr error
+	for _, u := range candidates {
+		err := c.fetchFrom(ctx, logger.With("upstream", u.url), u, f, shasum)
+		if err == nil {
+			c.upstreams.markSuccess(u)
+			return nil
+		}
+
+		// Shutdown or the fill timeout, no point in trying other upstreams.
+		if ctx.Err() != nil {
+			return err
+		}
+
+		// Mirrors may lag behind or not keep old releases, a missing file is not a failure of the upstream.
+		if errors.Is(err, errUpstreamNotFound) {
+			if lastErr == nil {
+				lastErr = err
+			}
+		} else {
+			c.upstreams.markFailure(logger, u)
+			lastErr = err
+		}
+
+		// Clients already received bytes from this upstream, switching to another one would mix two transfers.
+		// The fill fails instead and the next request resumes or starts over.
+		if f.isStarted() {
+			return err
+		}
 	}
 
-	// Create a temporary file to avoid serving a partially downloaded file.
-	tmpFile, err := os.CreateTemp(c.cacheDir, filename+".*.tmp")
+	return lastErr
+}
+
+// Downloads a file from a single upstream, verifies and caches it.
+func (c *Cache) fetchFrom(ctx context.Context, logger *slog.Logger, u *upstream, f *fill, shasum string) error {
+	filename := f.filename
+	sourceURL := u.artifactURL(filename, f.version)
+	pathDestination := filepath.Dir(f.finalPath)
+
+	logger = logger.With("source_url", sourceURL)
+
+	// The signature is small, fetching it first avoids downloading a tarball that can't be verified.
+	var signature *zig.Signature
+	var rawSignature []byte
+	if c.publicKey != nil && !strings.HasSuffix(filename, ".minisig") {
+		var err error
+		signature, rawSignature, err = c.fetchSignature(ctx, logger, u.artifactURL(filename+".minisig", f.version), filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Resume an interrupted download of the same upstream file if there is one.
+	meta, offset := c.loadPartial(logger, filename, sourceURL)
+
+	resp, offset, err := c.requestUpstream(ctx, logger, sourceURL, meta, offset)
 	if err != nil {
-		logger.Error("failed to create temporary file", "error", err)
 		return err
 	}
+	defer resp.Body.Close()
 
-	// Stream the download to the temp file.
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		if err := tmpFile.Close(); err != nil {
-			logger.Error("failed to close the temporary file", "temp_file", tmpFile.Name(), "error", err)
+	if offset == 0 {
+		if err := c.savePartialMeta(filename, newPartialMeta(sourceURL, resp)); err != nil {
+			logger.Warn("failed to store the validators of the download, it can't be resumed if interrupted", "error", err)
 		}
+	}
 
-		if err := os.Remove(tmpFile.Name()); err != nil {
-			logger.Error("failed to remove the temporary file", "temp_file", tmpFile.Name(), "error", err)
-		}
+	// Write to a partial file first to avoid serving a partially downloaded file from the cache.
+	tmpFile, err := c.openPartial(filename, offset)
+	if err != nil {
+		logger.Error("failed to create temporary file", "error", err)
+		return err
+	}
 
-		logger.Error("failed to write to temporary file", "temp_file", tmpFile.Name(), "error", err)
+	// The checksum covers the whole file, including the part downloaded by an earlier attempt.
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(tmpFile, 0, offset)); err != nil {
+		tmpFile.Close()
+		c.removePartial(logger, filename)
+		logger.Error("failed to read the partial download", "temp_file", tmpFile.Name(), "error", err)
 		return err
 	}
 
-	// See: https://pkg.go.dev/os#example-CreateTemp-Suffix
-	// Also: On Linux it is allowed to delete an opened file, while on Windows it is not allowed
-	if err := tmpFile.Close(); err != nil {
-		logger.Error("failed to close the temporary file", "temp_file", tmpFile.Name(), "error", err)
+	size := int64(-1)
+	if resp.ContentLength >= 0 {
+		size = offset + resp.ContentLength
 	}
 
-	// Create destination directory for the file.
-	if err := os.MkdirAll(pathDestination, 0775); err != nil {
-		logger.Error("failed to create destination path for storing the cached artifact", "path_destination", pathDestination, "error", err)
+	// From here on, readers follow the temporary file while it grows.
+	f.attach(tmpFile, offset, size)
+
+	// Stream the download to the temp file, hashing it on the way.
+	n, err := io.Copy(io.MultiWriter(f, hash), resp.Body)
+	c.metrics.FillBytes.Add(float64(n), upstreamLabel(sourceURL))
+	if err != nil {
+		// Keep what we have, the next attempt resumes from here.
+		f.discard()
+		logger.Error("failed to download the file, keeping the partial download for a later resume", "temp_file", tmpFile.Name(), "downloaded_bytes", f.state().written, "error", err)
 		return err
 	}
 
-	// Atomically move the file to its final destination.
-	fileDestination := filepath.Join(pathDestination, filename)
-	if err := os.Rename(tmpFile.Name(), fileDestination); err != nil {
-		if err := os.Remove(tmpFile.Name()); err != nil {
-			logger.Error("failed to remove the temporary file", "temp_file", tmpFile.Name(), "error", err)
+	if sum := hex.EncodeToString(hash.Sum(nil)); shasum != "" && sum != shasum {
+		f.discard()
+		c.removePartial(logger, filename)
+		logger.Error("downloaded file does not match the checksum from index.json", "expected_shasum", shasum, "actual_shasum", sum)
+		return errChecksumMismatch
+	}
+
+	if signature != nil {
+		if err := c.verifySignature(logger, *signature, tmpFile.Name()); err != nil {
+			f.discard()
+			c.removePartial(logger, filename)
+			return err
 		}
+	}
 
-		logger.Error("failed to rename temporary file", "from", tmpFile.Name(), "to", fileDestination, "error", err)
+	// Atomically move the file to its final destination.
+	if err := c.evictor.track(f.commit, f.finalPath); err != nil {
+		c.removePartial(logger, filename)
+		logger.Error("failed to move the temporary file to its destination", "from", tmpFile.Name(), "to", f.finalPath, "error", err)
 		return err
 	}
+	c.removeTemp(logger, c.partialPath(filename)+".json")
+
+	// Keep the verified signature next to the artifact, clients usually ask for it right after.
+	if rawSignature != nil {
+		signaturePath := filepath.Join(pathDestination, filename+".minisig")
+		err := c.evictor.track(func() error { return atomicfile.WriteFile(signaturePath, rawSignature) }, signaturePath)
+		if err != nil {
+			logger.Warn("failed to cache the artifact signature", "error", err)
+		}
+	}
 
-	logger.Info("successfully downloaded and cached file")
+	logger.Info("successfully downloaded and cached file", "upstream", u.url)
 	return nil
 }
 
+// Sends the upstream request for a file. A partial download of offset bytes is resumed with a Range request,
+// as long as upstream confirms (If-Range) that the file did not change since. Otherwise the download starts over.
+// Returns the response and the offset its body starts at.
+func (c *Cache) requestUpstream(ctx context.Context, logger *slog.Logger, sourceURL string, meta partialMeta, offset int64) (*http.Response, int64, error) {
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+		if err != nil {
+			logger.Error("failed to create upstream request", "error", err)
+			return nil, 0, err
+		}
+
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", meta.ifRange())
+			logger.Info("resuming a partial download from upstream", "offset", offset)
+		} else {
+			logger.Info("fetching file from upstream")
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			logger.Error("failed to fetch file from upstream", "error", err)
+			return nil, 0, errUpstreamUnavailable
+		}
+		c.recordUpstreamResponse(sourceURL, resp.StatusCode)
+
+		switch {
+		case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+			start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+			if err == nil && start == offset && meta.matches(resp) {
+				return resp, offset, nil
+			}
+
+			resp.Body.Close()
+			logger.Warn("upstream returned a range that doesn't match the partial download, starting over", "content_range", resp.Header.Get("Content-Range"))
+			offset = 0
+			continue
+
+		case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+			resp.Body.Close()
+			logger.Warn("upstream can't resume the partial download, starting over")
+			offset = 0
+			continue
+
+		case resp.StatusCode == http.StatusOK:
+			if offset > 0 {
+				logger.Info("upstream file changed since the partial download, starting over")
+			}
+			return resp, 0, nil
+
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			logger.Warn("file not found on upstream")
+			return nil, 0, errUpstreamNotFound
+
+		default:
+			resp.Body.Close()
+			logger.Error("upstream server returned non-OK status", "status_code", resp.StatusCode)
+			return nil, 0, errUpstreamUnavailable
+		}
+	}
+}
+
+// Removes a temporary file, the name may be empty if there is nothing to remove.
+func (c *Cache) removeTemp(logger *slog.Logger, name string) {
+	if name == "" {
+		return
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		logger.Error("failed to remove the temporary file", "temp_file", name, "error", err)
+	}
+}
+
+// Returns the cache directory of an artifact: builds/ for dev builds and download/<version>/ for releases.
+func (c *Cache) destinationDir(version string) string {
+	if strings.Contains(version, "-dev") {
+		return filepath.Join(c.cacheDir, "builds")
+	}
+	return filepath.Join(c.cacheDir, "download", version)
+}
+
+// Streams a file that is still being downloaded.
+// Range requests are not supported until the file is cached, the whole file is sent instead.
+func serveFill(w http.ResponseWriter, r *http.Request, f *fill, logger *slog.Logger) {
+	logger.Info("streaming file while it is downloaded")
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if size := f.state().size; size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	reader := f.newReader(r.Context())
+	defer reader.Close()
+
+	if _, err := io.Copy(w, reader); err != nil {
+		if r.Context().Err() == nil {
+			// The status is already sent, aborting the connection is the only way
+			// to tell the client that the file is incomplete.
+			logger.Error("failed to stream the file", "error", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
 func serveFile(w http.ResponseWriter, r *http.Request, path string, logger *slog.Logger) {
 	logger.Info("serving file from cache")
 	w.Header().Set("Content-Type", "application/octet-stream")
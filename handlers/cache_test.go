@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+const testArtifact = "zig-x86_64-linux-0.14.1.tar.xz"
+
+// Creates a fake upstream serving index.json and a single artifact with the provided content.
+// The shasum listed in index.json is the one of listedContent.
+func newTestUpstream(t *testing.T, content, listedContent string) *httptest.Server {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(listedContent))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download/index.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{
+			"0.14.1": {
+				"version": "0.14.1",
+				"x86_64-linux": {
+					"tarball": "https://ziglang.org/download/0.14.1/%s",
+					"shasum": "%s",
+					"size": "%d"
+				}
+			}
+		}`, testArtifact, hex.EncodeToString(sum[:]), len(listedContent))
+	})
+	mux.HandleFunc("/download/0.14.1/{file}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Requests a file from a mirror backed by the cache and returns the response status.
+// A transfer that is aborted after the status was sent (a failed verification during streaming)
+// is reported as http.StatusBadGateway, a successful one must deliver the expected content.
+func fetchFromMirror(t *testing.T, cache *Cache, path, expected string) int {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download/", cache.Handler())
+	mirror := httptest.NewServer(mux)
+	defer mirror.Close()
+
+	resp, err := http.Get(mirror.URL + path)
+	if err != nil {
+		// The connection was aborted before the buffered status line was flushed.
+		return http.StatusBadGateway
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return http.StatusBadGateway
+	}
+
+	if resp.StatusCode == http.StatusOK && string(body) != expected {
+		t.Errorf("got body %q, want %q", body, expected)
+	}
+
+	return resp.StatusCode
+}
+
+func TestCacheHandlerChecksum(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		filename       string
+		content        string
+		listedContent  string
+		unlisted       UnlistedPolicy
+		expectedStatus int
+		expectCached   bool
+	}{
+		{
+			name:           "checksum matches",
+			filename:       testArtifact,
+			content:        "zig tarball",
+			listedContent:  "zig tarball",
+			unlisted:       UnlistedReject,
+			expectedStatus: http.StatusOK,
+			expectCached:   true,
+		},
+		{
+			name:           "checksum mismatch",
+			filename:       testArtifact,
+			content:        "tampered tarball",
+			listedContent:  "zig tarball",
+			unlisted:       UnlistedAccept,
+			expectedStatus: http.StatusBadGateway,
+			expectCached:   false,
+		},
+		{
+			name:           "unlisted artifact accepted",
+			filename:       "zig-aarch64-linux-0.14.1.tar.xz",
+			content:        "zig tarball",
+			listedContent:  "zig tarball",
+			unlisted:       UnlistedAccept,
+			expectedStatus: http.StatusOK,
+			expectCached:   true,
+		},
+		{
+			name:           "unlisted artifact rejected",
+			filename:       "zig-aarch64-linux-0.14.1.tar.xz",
+			content:        "zig tarball",
+			listedContent:  "zig tarball",
+			unlisted:       UnlistedReject,
+			expectedStatus: http.StatusNotFound,
+			expectCached:   false,
+		},
+		{
+			name:           "signatures are not checked against the index",
+			filename:       testArtifact + ".minisig",
+			content:        "signature",
+			listedContent:  "zig tarball",
+			unlisted:       UnlistedReject,
+			expectedStatus: http.StatusOK,
+			expectCached:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			upstream := newTestUpstream(t, tt.content, tt.listedContent)
+			cacheDir := t.TempDir()
+
+			cache := NewCache(CacheOptions{
+				UpstreamHost: upstream.URL,
+				CacheDir:     cacheDir,
+				Index:        zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, ""),
+				Unlisted:     tt.unlisted,
+			})
+
+			status := fetchFromMirror(t, cache, "/download/0.14.1/"+tt.filename, tt.content)
+			if status != tt.expectedStatus {
+				t.Errorf("got status %v, want %v", status, tt.expectedStatus)
+			}
+
+			cached := fileExists(filepath.Join(cacheDir, "download", "0.14.1", tt.filename))
+			if cached != tt.expectCached {
+				t.Errorf("got cached %v, want %v", cached, tt.expectCached)
+			}
+
+			entries, err := os.ReadDir(cacheDir)
+			if err != nil {
+				t.Fatalf("failed to read the cache directory: %v", err)
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					t.Errorf("unexpected leftover file %q in the cache directory", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestCacheHandlerIndexUnavailable(t *testing.T) {
+	t.Parallel()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(broken.Close)
+
+	for _, unlisted := range []UnlistedPolicy{UnlistedAccept, UnlistedReject} {
+		t.Run(string(unlisted), func(t *testing.T) {
+			t.Parallel()
+
+			upstream := newTestUpstream(t, "zig tarball", "zig tarball")
+			cacheDir := t.TempDir()
+
+			cache := NewCache(CacheOptions{
+				UpstreamHost: upstream.URL,
+				CacheDir:     cacheDir,
+				Index:        zig.NewIndex([]string{broken.URL + "/download/index.json"}, time.Minute, ""),
+				Unlisted:     unlisted,
+			})
+
+			// The artifact can't be verified, it is neither cached unchecked nor reported as missing.
+			if status := fetchFromMirror(t, cache, "/download/0.14.1/"+testArtifact, "zig tarball"); status != http.StatusBadGateway {
+				t.Errorf("got status %v, want %v", status, http.StatusBadGateway)
+			}
+			if fileExists(filepath.Join(cacheDir, "download", "0.14.1", testArtifact)) {
+				t.Errorf("got a cached artifact without checksum verification")
+			}
+		})
+	}
+}
+
+func TestCachePrefetch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		filename     string
+		content      string
+		expectCached bool
+		expectError  bool
+	}{
+		{"listed artifact", testArtifact, "zig tarball", true, false},
+		{"checksum mismatch", testArtifact, "tampered tarball", false, true},
+		{"invalid filename", "index.html", "zig tarball", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			upstream := newTestUpstream(t, tt.content, "zig tarball")
+			cacheDir := t.TempDir()
+
+			cache := NewCache(CacheOptions{
+				UpstreamHost: upstream.URL,
+				CacheDir:     cacheDir,
+				Index:        zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, ""),
+				Unlisted:     UnlistedReject,
+			})
+
+			alreadyCached, err := cache.Prefetch(context.Background(), tt.filename)
+			if (err != nil) != tt.expectError || alreadyCached {
+				t.Fatalf("got (%v, %v), want an error: %v", alreadyCached, err, tt.expectError)
+			}
+
+			cached := fileExists(filepath.Join(cacheDir, "download", "0.14.1", tt.filename))
+			if cached != tt.expectCached {
+				t.Errorf("got cached %v, want %v", cached, tt.expectCached)
+			}
+
+			// A second run skips what is already cached.
+			if tt.expectCached {
+				if alreadyCached, err := cache.Prefetch(context.Background(), tt.filename); err != nil || !alreadyCached {
+					t.Errorf("got (%v, %v) on the second run, want an already cached artifact", alreadyCached, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheOffline(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("got an upstream request for %v while offline", r.URL.Path)
+	}))
+	t.Cleanup(upstream.Close)
+
+	cacheDir := t.TempDir()
+	dir := filepath.Join(cacheDir, "download", "0.14.1")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, testArtifact), []byte("zig tarball"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	indexFile := filepath.Join(cacheDir, "download", "index.json")
+	if err := os.WriteFile(indexFile, []byte(`{
+		"0.14.1": {
+			"version": "0.14.1",
+			"x86_64-linux": {"tarball": "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz"},
+			"aarch64-linux": {"tarball": "https://ziglang.org/download/0.14.1/zig-aarch64-linux-0.14.1.tar.xz"}
+		}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache(CacheOptions{
+		UpstreamHost: upstream.URL,
+		CacheDir:     cacheDir,
+		Index:        zig.NewIndex(nil, time.Minute, indexFile),
+		Offline:      true,
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+	}{
+		{"cached artifact", "/download/0.14.1/" + testArtifact, http.StatusOK},
+		{"listed artifact", "/download/0.14.1/zig-aarch64-linux-0.14.1.tar.xz", http.StatusServiceUnavailable},
+		{"listed signature", "/download/0.14.1/zig-aarch64-linux-0.14.1.tar.xz.minisig", http.StatusServiceUnavailable},
+		{"unlisted artifact", "/download/0.14.0/zig-x86_64-linux-0.14.0.tar.xz", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if status := fetchFromMirror(t, cache, tt.path, "zig tarball"); status != tt.expectedStatus {
+				t.Errorf("got status %v, want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+
+	if _, err := cache.Prefetch(context.Background(), "zig-aarch64-linux-0.14.1.tar.xz"); err == nil {
+		t.Errorf("got no error prefetching while offline")
+	}
+}
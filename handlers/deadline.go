@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// Maximum number of bytes written to a download between two extensions of its write deadline.
+const deadlineChunk = 64 << 10
+
+// WriteTimeouts holds the write deadlines of the responses, they replace the WriteTimeout of the http.Server,
+// which limits every response including large downloads.
+type WriteTimeouts struct {
+	// Response limits the whole response of the routes that are not artifact downloads.
+	Response time.Duration
+	// DownloadIdle is the time an artifact download can go without progress, e.g. because the client stopped reading.
+	// The deadline is extended before every write, so downloads are not aborted while data keeps flowing.
+	DownloadIdle time.Duration
+	// Download limits the whole artifact download, zero means no limit.
+	Download time.Duration
+}
+
+// Sets the write deadline of every response through http.ResponseController, artifacts get a progress deadline.
+func (t WriteTimeouts) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+
+		if !zig.IsZigArtifact(path.Base(r.URL.Path)) {
+			// Fails with http.ErrNotSupported if the connection has no deadlines.
+			rc.SetWriteDeadline(time.Now().Add(t.Response))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		dw := &deadlineWriter{ResponseWriter: w, rc: rc, idle: t.DownloadIdle}
+		if t.Download > 0 {
+			dw.end = time.Now().Add(t.Download)
+		}
+		dw.extend()
+		next.ServeHTTP(dw, r)
+	})
+}
+
+// A response writer that extends the write deadline of the connection while the response makes progress.
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc   *http.ResponseController
+	idle time.Duration
+	// end is the deadline of the whole response, zero means none.
+	end time.Time
+}
+
+// Gives the next write the idle timeout to complete, but not past the end of the response.
+func (w *deadlineWriter) extend() {
+	deadline := time.Now().Add(w.idle)
+	if !w.end.IsZero() && w.end.Before(deadline) {
+		deadline = w.end
+	}
+	w.rc.SetWriteDeadline(deadline)
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		w.extend()
+		n, err := w.ResponseWriter.Write(p[:min(len(p), deadlineChunk)])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ReadFrom keeps the io.ReaderFrom of the underlying response writer,
+// and sends the data in chunks to extend the deadline in between.
+func (w *deadlineWriter) ReadFrom(src io.Reader) (int64, error) {
+	var written int64
+	for {
+		w.extend()
+
+		var n int64
+		var err error
+		chunk := io.LimitReader(src, deadlineChunk)
+		if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+			n, err = rf.ReadFrom(chunk)
+		} else {
+			n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, chunk)
+		}
+		written += n
+		if err != nil || n < deadlineChunk {
+			return written, err
+		}
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying response writer.
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriteTimeouts(t *testing.T) {
+	t.Parallel()
+
+	const (
+		chunks = 4
+		// Larger than the socket buffers, so that the writes block while the client doesn't read.
+		largeChunk = 16 << 20
+	)
+
+	tests := []struct {
+		name        string
+		path        string
+		chunkSize   int
+		pause       time.Duration // between the chunks of the response
+		clientStall time.Duration // before the client reads the body
+		download    time.Duration
+		expectError bool
+	}{
+		{"fast index page", "/", 1024, 0, 0, 0, false},
+		{"slow index page", "/", 1024, 100 * time.Millisecond, 0, 0, true},
+		{"slow but steady download", "/download/0.14.1/" + testArtifact, 1024, 100 * time.Millisecond, 0, 0, false},
+		{"stalled client", "/download/0.14.1/" + testArtifact, largeChunk, 0, time.Second, 0, true},
+		{"download over the total limit", "/download/0.14.1/" + testArtifact, 1024, 100 * time.Millisecond, 0, 250 * time.Millisecond, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			chunk := bytes.Repeat([]byte("z"), tt.chunkSize)
+			result := make(chan error, 1)
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for range chunks {
+					time.Sleep(tt.pause)
+					if _, err := w.Write(chunk); err != nil {
+						result <- err
+						return
+					}
+					if err := http.NewResponseController(w).Flush(); err != nil {
+						result <- err
+						return
+					}
+				}
+				result <- nil
+			}), MiddlewareOptions{WriteTimeouts: &WriteTimeouts{
+				Response:     200 * time.Millisecond,
+				DownloadIdle: 300 * time.Millisecond,
+				Download:     tt.download,
+			}})
+			server := httptest.NewServer(handler)
+			t.Cleanup(server.Close)
+
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			time.Sleep(tt.clientStall)
+			io.Copy(io.Discard, resp.Body)
+
+			if err := <-result; (err != nil) != tt.expectError {
+				t.Errorf("got write error %v, want an error: %v", err, tt.expectError)
+			}
+		})
+	}
+}
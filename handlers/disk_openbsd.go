@@ -0,0 +1,12 @@
+package handlers
+
+import "golang.org/x/sys/unix"
+
+// Returns the number of bytes available to the server on the file system of path.
+func freeSpace(path string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.F_bavail * int64(st.F_bsize), nil
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || dragonfly || netbsd || openbsd || windows)
+
+package handlers
+
+import "errors"
+
+// Free space can't be determined on this platform.
+func freeSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package handlers
+
+import "golang.org/x/sys/unix"
+
+// Returns the number of bytes available to the server on the file system of path.
+func freeSpace(path string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import "golang.org/x/sys/windows"
+
+// Returns the number of bytes available to the server on the file system of path.
+func freeSpace(path string) (int64, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return int64(available), nil
+}
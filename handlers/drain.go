@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Interval at which Drain checks for the remaining transfers and fills.
+const drainPollInterval = 100 * time.Millisecond
+
+// DrainReport summarizes the transfers and upstream fills that finished or were still in progress at the end of a drain.
+type DrainReport struct {
+	CompletedTransfers int
+	AbandonedTransfers int
+	// CompletedFills and AbandonedFills are file names, completed fills include the ones that failed.
+	CompletedFills []string
+	AbandonedFills []string
+}
+
+// Drain waits until no artifacts are sent to clients and no upstream fills are in progress, or until ctx is done.
+// Fills that are still queued are cancelled right away and reported as abandoned, and no new fills start afterwards.
+// Nothing else is stopped: the servers stop accepting connections through http.Server.Shutdown,
+// and the fills that remain are abandoned by cancelling CacheOptions.BaseContext.
+func (c *Cache) Drain(ctx context.Context) DrainReport {
+	startedBefore := c.transfersStarted.Load()
+	activeBefore := c.transfers.Load()
+	cancelled := c.cancelQueuedFills()
+	seen := make(map[string]bool)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		fills := c.activeFills()
+		for _, filename := range fills {
+			seen[filename] = true
+		}
+		active := c.transfers.Load()
+
+		if (active == 0 && len(fills) == 0) || ctx.Err() != nil {
+			abandoned := append(fills, cancelled...)
+			slices.Sort(abandoned)
+			report := DrainReport{
+				CompletedTransfers: int(activeBefore + c.transfersStarted.Load() - startedBefore - active),
+				AbandonedTransfers: int(active),
+				AbandonedFills:     slices.Compact(abandoned),
+			}
+			for _, filename := range slices.Sorted(maps.Keys(seen)) {
+				if !slices.Contains(report.AbandonedFills, filename) {
+					report.CompletedFills = append(report.CompletedFills, filename)
+				}
+			}
+			return report
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+// Cancels the fills waiting for a free slot, and returns their file names.
+func (c *Cache) cancelQueuedFills() []string {
+	c.fillsMu.Lock()
+	defer c.fillsMu.Unlock()
+
+	var cancelled []string
+	for _, t := range c.scheduler.close(errDraining) {
+		for filename, f := range c.fills {
+			if f.ticket == t {
+				cancelled = append(cancelled, filename)
+			}
+		}
+	}
+	return cancelled
+}
+
+// Returns the sorted file names of the fills that are in progress or queued.
+func (c *Cache) activeFills() []string {
+	c.fillsMu.Lock()
+	defer c.fillsMu.Unlock()
+	return slices.Sorted(maps.Keys(c.fills))
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+func TestCacheDrain(t *testing.T) {
+	t.Parallel()
+
+	const content = "zig tarball"
+
+	tests := []struct {
+		name            string
+		uploadDelay     time.Duration // before upstream sends the artifact
+		timeout         time.Duration
+		expectCompleted bool
+	}{
+		{"finished before the deadline", 100 * time.Millisecond, 5 * time.Second, true},
+		{"abandoned at the deadline", 5 * time.Second, 200 * time.Millisecond, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			sum := sha256.Sum256([]byte(content))
+			mux := http.NewServeMux()
+			mux.HandleFunc("/download/index.json", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"0.14.1": {"version": "0.14.1", "x86_64-linux": {"tarball": "https://ziglang.org/download/0.14.1/%s", "shasum": "%s", "size": "%d"}}}`,
+					testArtifact, hex.EncodeToString(sum[:]), len(content))
+			})
+			mux.HandleFunc("/download/0.14.1/{file}", func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.uploadDelay):
+					w.Write([]byte(content))
+				case <-r.Context().Done():
+				}
+			})
+			upstream := httptest.NewServer(mux)
+			t.Cleanup(upstream.Close)
+
+			baseCtx, abandon := context.WithCancel(context.Background())
+			t.Cleanup(abandon)
+
+			cache := NewCache(CacheOptions{
+				UpstreamHost: upstream.URL,
+				CacheDir:     t.TempDir(),
+				Index:        zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, ""),
+				Unlisted:     UnlistedReject,
+				BaseContext:  baseCtx,
+			})
+
+			mirrorMux := http.NewServeMux()
+			mirrorMux.HandleFunc("/download/", cache.Handler())
+			mirror := httptest.NewServer(mirrorMux)
+			t.Cleanup(mirror.Close)
+
+			go func() {
+				resp, err := http.Get(mirror.URL + "/download/0.14.1/" + testArtifact)
+				if err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			}()
+
+			// Wait for the transfer and the fill to start.
+			for cache.transfers.Load() == 0 || len(cache.activeFills()) == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			report := cache.Drain(ctx)
+			// Like the server shutdown, the remaining fills are abandoned after the drain.
+			abandon()
+
+			completed := report.CompletedTransfers == 1 && slices.Equal(report.CompletedFills, []string{testArtifact})
+			abandoned := report.AbandonedTransfers == 1 && slices.Equal(report.AbandonedFills, []string{testArtifact})
+			if completed != tt.expectCompleted || abandoned == tt.expectCompleted {
+				t.Errorf("got %+v, want completed: %v", report, tt.expectCompleted)
+			}
+		})
+	}
+}
+
+func TestCacheDrainQueuedFills(t *testing.T) {
+	t.Parallel()
+
+	const running = testArtifact
+	const queued = "zig-aarch64-linux-0.14.1.tar.xz"
+
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download/index.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"0.14.1": {"version": "0.14.1",
+			"x86_64-linux": {"tarball": "https://ziglang.org/download/0.14.1/%s", "shasum": "00", "size": "1"},
+			"aarch64-linux": {"tarball": "https://ziglang.org/download/0.14.1/%s", "shasum": "00", "size": "1"}}}`, running, queued)
+	})
+	mux.HandleFunc("/download/0.14.1/{file}", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-r.Context().Done()
+	})
+	upstream := httptest.NewServer(mux)
+	t.Cleanup(upstream.Close)
+
+	baseCtx, abandon := context.WithCancel(context.Background())
+	t.Cleanup(abandon)
+
+	cache := NewCache(CacheOptions{
+		UpstreamHost: upstream.URL,
+		CacheDir:     t.TempDir(),
+		Index:        zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, ""),
+		Unlisted:     UnlistedReject,
+		BaseContext:  baseCtx,
+		MaxFills:     1,
+	})
+
+	go cache.Prefetch(context.Background(), running)
+	for requests.Load() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	queuedErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Prefetch(context.Background(), queued)
+		queuedErr <- err
+	}()
+	for _, waiting := cache.scheduler.stats(); waiting == 0; _, waiting = cache.scheduler.stats() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	report := cache.Drain(ctx)
+	abandon()
+
+	if want := []string{queued, running}; !slices.Equal(report.AbandonedFills, want) || len(report.CompletedFills) != 0 {
+		t.Errorf("got %+v, want abandoned fills %v", report, want)
+	}
+	if err := <-queuedErr; !errors.Is(err, errDraining) {
+		t.Errorf("got error %v for the queued fill, want %v", err, errDraining)
+	}
+	// The queued fill never reached upstream.
+	if got := requests.Load(); got != 1 {
+		t.Errorf("got %v upstream requests, want %v", got, 1)
+	}
+}
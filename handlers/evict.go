@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"cmp"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// EvictionPolicy decides which artifacts are removed first once the cache exceeds its size limit.
+type EvictionPolicy string
+
+const (
+	// EvictLRU removes the least recently used artifacts first.
+	EvictLRU EvictionPolicy = "lru"
+	// EvictLFU removes the least frequently used artifacts first.
+	EvictLFU EvictionPolicy = "lfu"
+	// EvictOldestVersion removes the artifacts of the oldest Zig versions first.
+	EvictOldestVersion EvictionPolicy = "oldest-version"
+)
+
+// accessStats tracks how an artifact is used.
+type accessStats struct {
+	lastAccess time.Time
+	hits       int64
+	pins       int // requests currently serving or filling the artifact
+}
+
+// cachedGroup is an artifact together with its signature, they are always evicted together.
+type cachedGroup struct {
+	paths      []string
+	version    string
+	size       int64
+	lastAccess time.Time
+	hits       int64
+	pinned     bool
+}
+
+// evictor keeps the cache below its size limit.
+type evictor struct {
+	cacheDir string
+	maxSize  int64 // bytes, zero means unlimited
+	policy   EvictionPolicy
+
+	enforceMu sync.Mutex // one enforcement at a time
+
+	mu    sync.Mutex
+	stats map[string]*accessStats // keyed by the full path of the artifact
+
+	// Size of the cached artifacts and their signatures, see cacheSize.
+	// It is counted once on start and then updated by every change made through track.
+	size atomic.Int64
+}
+
+func newEvictor(cacheDir string, maxSize int64, policy EvictionPolicy) *evictor {
+	e := &evictor{
+		cacheDir: cacheDir,
+		maxSize:  maxSize,
+		policy:   policy,
+		stats:    make(map[string]*accessStats),
+	}
+	e.size.Store(cacheSize(cacheDir))
+	return e
+}
+
+// Runs a change of the cached files at paths (e.g. a rename from one to the other)
+// and updates the size of the cache by the difference of their sizes.
+func (e *evictor) track(change func() error, paths ...string) error {
+	var before int64
+	for _, path := range paths {
+		before += e.countedSize(path)
+	}
+
+	err := change()
+
+	var after int64
+	for _, path := range paths {
+		after += e.countedSize(path)
+	}
+	e.size.Add(after - before)
+
+	return err
+}
+
+// Returns the size of a file if it counts towards the size of the cache, zero otherwise.
+func (e *evictor) countedSize(path string) int64 {
+	if !zig.IsZigArtifact(filepath.Base(path)) {
+		return 0
+	}
+
+	rel, err := filepath.Rel(e.cacheDir, path)
+	if err != nil {
+		return 0
+	}
+	if dir, _, _ := strings.Cut(filepath.ToSlash(rel), "/"); dir != "download" && dir != "builds" {
+		return 0
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+func (e *evictor) statsLocked(path string) *accessStats {
+	s, ok := e.stats[path]
+	if !ok {
+		s = &accessStats{}
+		e.stats[path] = s
+	}
+	return s
+}
+
+// Records a cache hit of the artifact, misses and fills don't count.
+// The access time of the file is set as well, so that the recency survives a restart of the server.
+func (e *evictor) touch(path string) {
+	if e.maxSize <= 0 {
+		return
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, time.Time{})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s := e.statsLocked(path)
+	s.lastAccess = now
+	s.hits++
+}
+
+// Protects the artifact from eviction until the returned function is called.
+func (e *evictor) pin(path string) func() {
+	if e.maxSize <= 0 {
+		return func() {}
+	}
+
+	e.mu.Lock()
+	e.statsLocked(path).pins++
+	e.mu.Unlock()
+
+	return func() {
+		e.mu.Lock()
+		e.statsLocked(path).pins--
+		e.mu.Unlock()
+	}
+}
+
+// Returns a copy of the stats, so that the cache directory can be walked without holding the lock.
+func (e *evictor) snapshot() map[string]accessStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := make(map[string]accessStats, len(e.stats))
+	for path, s := range e.stats {
+		stats[path] = *s
+	}
+	return stats
+}
+
+// Returns all cached artifacts grouped with their signatures and the total size of the cache.
+func (e *evictor) scan(stats map[string]accessStats) ([]*cachedGroup, int64, error) {
+	groups := make(map[string]*cachedGroup)
+	var total int64
+
+	for _, dir := range []string{"download", "builds"} {
+		err := filepath.WalkDir(filepath.Join(e.cacheDir, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !zig.IsZigArtifact(d.Name()) {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+
+			key := strings.TrimSuffix(path, ".minisig")
+			g, ok := groups[key]
+			if !ok {
+				g = &cachedGroup{version: zig.ArtifactSubmatches(d.Name())[1]}
+				groups[key] = g
+			}
+
+			// Artifacts that were not used since the start of the server were last used when they were read or downloaded.
+			lastAccess := info.ModTime()
+			if atime := accessTime(info); atime.After(lastAccess) {
+				lastAccess = atime
+			}
+			if s, ok := stats[path]; ok {
+				if s.lastAccess.After(lastAccess) {
+					lastAccess = s.lastAccess
+				}
+				g.hits += s.hits
+				g.pinned = g.pinned || s.pins > 0
+			}
+
+			g.paths = append(g.paths, path)
+			g.size += info.Size()
+			if lastAccess.After(g.lastAccess) {
+				g.lastAccess = lastAccess
+			}
+
+			total += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return slices.Collect(maps.Values(groups)), total, nil
+}
+
+// Forgets the artifacts that were removed by other means (e.g. the dev builds cleanup).
+func (e *evictor) forgetRemoved() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for path, s := range e.stats {
+		if s.pins == 0 && !fileExists(path) {
+			delete(e.stats, path)
+		}
+	}
+}
+
+// Orders the groups so that the first one is evicted first.
+func (e *evictor) sort(groups []*cachedGroup) {
+	byAccess := func(a, b *cachedGroup) int {
+		return a.lastAccess.Compare(b.lastAccess)
+	}
+
+	switch e.policy {
+	case EvictLFU:
+		slices.SortFunc(groups, func(a, b *cachedGroup) int {
+			return cmp.Or(cmp.Compare(a.hits, b.hits), byAccess(a, b))
+		})
+	case EvictOldestVersion:
+		slices.SortFunc(groups, func(a, b *cachedGroup) int {
+			return cmp.Or(zig.CompareVersions(a.version, b.version), byAccess(a, b))
+		})
+	default:
+		slices.SortFunc(groups, byAccess)
+	}
+}
+
+// Evicts artifacts until the cache fits into its size limit and returns the number of reclaimed bytes.
+// Artifacts that are being served or filled are never evicted.
+func (e *evictor) enforce(logger *slog.Logger) int64 {
+	if e.maxSize <= 0 {
+		return 0
+	}
+
+	e.enforceMu.Lock()
+	defer e.enforceMu.Unlock()
+
+	// The cache directory is walked without the lock, requests keep using the stats in the meantime.
+	groups, total, err := e.scan(e.snapshot())
+	if err != nil {
+		logger.Error("failed to scan the cache directory for eviction", "path", e.cacheDir, "error", err)
+		return 0
+	}
+	e.forgetRemoved()
+
+	if total <= e.maxSize {
+		return 0
+	}
+
+	e.sort(groups)
+
+	var removedCount int
+	var removedBytes int64
+
+	for _, g := range groups {
+		if total <= e.maxSize {
+			break
+		}
+		if g.pinned {
+			continue
+		}
+
+		count, size := e.evict(logger, g)
+		total -= size
+		removedCount += count
+		removedBytes += size
+	}
+
+	logger.Info("cache eviction completed", "removed_count", removedCount, "reclaimed_space", removedBytes, "cache_size", total, "max_cache_size", e.maxSize)
+
+	if total > e.maxSize {
+		logger.Warn("cache is still over its size limit, the remaining artifacts are in use", "cache_size", total, "max_cache_size", e.maxSize)
+	}
+
+	return removedBytes
+}
+
+// Removes an artifact with its signature, unless a request pinned it since the scan, and returns the number and size of the removed files.
+// Holding the lock while deleting prevents a request from pinning a file that is being evicted.
+func (e *evictor) evict(logger *slog.Logger, g *cachedGroup) (int, int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, path := range g.paths {
+		if s, ok := e.stats[path]; ok && s.pins > 0 {
+			return 0, 0
+		}
+	}
+
+	var count int
+	var size int64
+	for _, path := range g.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if err := e.track(func() error { return os.Remove(path) }, path); err != nil {
+			logger.Error("failed to evict a cached artifact", "path", path, "error", err)
+			continue
+		}
+
+		delete(e.stats, path)
+		count++
+		size += info.Size()
+	}
+	return count, size
+}
+
+// Returns the total size of the cached artifacts and their signatures.
+func cacheSize(cacheDir string) int64 {
+	var total int64
+	for _, dir := range []string{"download", "builds"} {
+		filepath.WalkDir(filepath.Join(cacheDir, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !zig.IsZigArtifact(d.Name()) {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+			return nil
+		})
+	}
+	return total
+}
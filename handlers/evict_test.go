@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+func TestEvictorEnforce(t *testing.T) {
+	t.Parallel()
+
+	type file struct {
+		path string
+		age  time.Duration
+		hits int
+	}
+
+	files := []file{
+		{"download/0.13.0/zig-x86_64-linux-0.13.0.tar.xz", 1 * time.Hour, 5},
+		{"download/0.13.0/zig-x86_64-linux-0.13.0.tar.xz.minisig", 1 * time.Hour, 0},
+		{"download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz", 3 * time.Hour, 1},
+		{"builds/zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz", 2 * time.Hour, 3},
+	}
+
+	tests := []struct {
+		name     string
+		policy   EvictionPolicy
+		maxSize  int64
+		pinned   string
+		expected []string // remaining artifacts
+	}{
+		{
+			name:     "under the limit",
+			policy:   EvictLRU,
+			maxSize:  1000,
+			expected: []string{files[0].path, files[1].path, files[2].path, files[3].path},
+		},
+		{
+			name:     "least recently used",
+			policy:   EvictLRU,
+			maxSize:  350,
+			expected: []string{files[0].path, files[1].path, files[3].path},
+		},
+		{
+			name:     "least frequently used",
+			policy:   EvictLFU,
+			maxSize:  250,
+			expected: []string{files[0].path, files[1].path},
+		},
+		{
+			name:     "oldest version, signature goes with its artifact",
+			policy:   EvictOldestVersion,
+			maxSize:  250,
+			expected: []string{files[2].path, files[3].path},
+		},
+		{
+			name:     "pinned artifacts are never evicted",
+			policy:   EvictLRU,
+			maxSize:  100,
+			pinned:   files[2].path,
+			expected: []string{files[2].path},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir := t.TempDir()
+			e := newEvictor(cacheDir, tt.maxSize, tt.policy)
+
+			for _, f := range files {
+				path := filepath.Join(cacheDir, f.path)
+				if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, make([]byte, 100), 0664); err != nil {
+					t.Fatal(err)
+				}
+				mtime := time.Now().Add(-f.age)
+				if err := os.Chtimes(path, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+				if f.hits > 0 {
+					e.stats[path] = &accessStats{lastAccess: mtime, hits: int64(f.hits)}
+				}
+			}
+
+			if tt.pinned != "" {
+				defer e.pin(filepath.Join(cacheDir, tt.pinned))()
+			}
+
+			e.enforce(slog.Default())
+
+			remaining := make(map[string]bool)
+			for _, f := range files {
+				if fileExists(filepath.Join(cacheDir, f.path)) {
+					remaining[f.path] = true
+				}
+			}
+
+			if len(remaining) != len(tt.expected) {
+				t.Errorf("got %d remaining artifacts %v, want %v", len(remaining), remaining, tt.expected)
+			}
+			for _, path := range tt.expected {
+				if !remaining[path] {
+					t.Errorf("artifact %v was evicted, want it to remain", path)
+				}
+			}
+		})
+	}
+}
+
+func TestEvictorStats(t *testing.T) {
+	t.Parallel()
+
+	content := "zig tarball"
+	upstream := newTestUpstream(t, content, content)
+	cacheDir := t.TempDir()
+
+	cache := NewCache(CacheOptions{
+		UpstreamHost: upstream.URL,
+		CacheDir:     cacheDir,
+		Index:        zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, ""),
+		Unlisted:     UnlistedReject,
+		MaxSize:      1000,
+		Eviction:     EvictLFU,
+	})
+
+	for range 3 {
+		if status := fetchFromMirror(t, cache, "/download/0.14.1/"+testArtifact, content); status != http.StatusOK {
+			t.Fatalf("got status %v, want %v", status, http.StatusOK)
+		}
+	}
+
+	// Only the requests served from the cache count, not the miss that filled it.
+	cached := filepath.Join(cacheDir, "download", "0.14.1", testArtifact)
+	if got := cache.evictor.snapshot()[cached].hits; got != 2 {
+		t.Errorf("got %v hits, want 2", got)
+	}
+
+	// The recency of the artifacts survives a restart through their access times.
+	old := filepath.Join(cacheDir, "download", "0.13.0", "zig-x86_64-linux-0.13.0.tar.xz")
+	if err := os.MkdirAll(filepath.Dir(old), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(old, []byte(content), 0664); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	for _, path := range []string{cached, old} {
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.evictor.touch(old)
+
+	restarted := newEvictor(cacheDir, int64(len(content)), EvictLRU)
+	restarted.enforce(slog.Default())
+
+	if fileExists(cached) || !fileExists(old) {
+		t.Errorf("got cached %v and recently used %v, want only the recently used artifact", fileExists(cached), fileExists(old))
+	}
+}
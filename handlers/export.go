@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/atomicfile"
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// Name of the SHA-256 manifest of an export bundle, in the format of sha256sum.
+const exportManifest = "SHA256SUMS"
+
+// ExportOptions selects the artifacts of an export bundle and where it is written.
+type ExportOptions struct {
+	// Versions and Platforms select the artifacts like zig.ZigReleases.Select, empty lists select everything.
+	Versions  []string
+	Platforms []string
+	// Output is the bundle directory, or a tar archive if it ends with .tar.
+	Output string
+}
+
+// ExportReport summarizes an export.
+type ExportReport struct {
+	Artifacts int
+	Bytes     int64
+	// Missing lists the selected artifacts that are not cached, Corrupt the ones that failed verification.
+	Missing []string
+	Corrupt []string
+}
+
+// Export writes the selected cached artifacts, their signatures, an index.json that only lists them
+// and a SHA-256 manifest into a bundle with the layout of the cache directory,
+// so that another mirror can serve it as its -cache-dir without reaching any upstream.
+// The tarball URLs in the bundled index.json are kept, the mirror serving it rewrites them with -public-url.
+// Every artifact is verified before it is exported, selected artifacts that are not cached are skipped.
+func (c *Cache) Export(ctx context.Context, opts ExportOptions) (ExportReport, error) {
+	var report ExportReport
+
+	if c.index == nil {
+		return report, errors.New("exporting requires index.json")
+	}
+	raw, _, err := c.index.Raw(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to fetch index.json: %w", err)
+	}
+	releases, err := zig.ParseReleases(raw)
+	if err != nil {
+		return report, fmt.Errorf("failed to parse index.json: %w", err)
+	}
+
+	var bundle bundleWriter
+	if strings.HasSuffix(opts.Output, ".tar") {
+		bundle, err = newTarBundle(opts.Output)
+	} else {
+		bundle, err = newDirBundle(opts.Output)
+	}
+	if err != nil {
+		return report, err
+	}
+	defer bundle.abort()
+
+	manifest := make(map[string]string) // path in the bundle to checksum
+	exported := make(map[string]bool)   // tarball URLs
+	devBuilds := make(map[string]bool)  // file names
+
+	for _, artifact := range releases.Select(opts.Versions, opts.Platforms) {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+
+		filename := path.Base(artifact.Tarball)
+		submatches := zig.ArtifactSubmatches(filename)
+		if submatches == nil {
+			slog.Warn("skipping an artifact with an unknown filename", "tarball", artifact.Tarball)
+			continue
+		}
+
+		cachedPath := filepath.Join(c.destinationDir(submatches[1]), filename)
+		name := c.relativePath(cachedPath)
+		logger := slog.With("path", cachedPath)
+
+		check, err := c.checkFile(ctx, cachedPath, cachedPath+".minisig", 0)
+		if errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("skipping a selected artifact that is not cached")
+			report.Missing = append(report.Missing, filename)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		if (artifact.Shasum != "" && check.sha256 != strings.ToLower(artifact.Shasum)) || check.signature == signatureInvalid {
+			logger.Error("skipping a cached artifact that failed verification", "signature", check.signature)
+			report.Corrupt = append(report.Corrupt, filename)
+			continue
+		}
+
+		if err := bundle.addFile(name, cachedPath); err != nil {
+			return report, err
+		}
+		manifest[name] = check.sha256
+
+		if signature, err := os.ReadFile(cachedPath + ".minisig"); err == nil {
+			if err := bundle.addData(name+".minisig", signature); err != nil {
+				return report, err
+			}
+			manifest[name+".minisig"] = sha256Hex(signature)
+		}
+
+		exported[artifact.Tarball] = true
+		if strings.Contains(submatches[1], "-dev") {
+			devBuilds[filename] = true
+		}
+		report.Artifacts++
+		report.Bytes += check.size
+		logger.Info("exported an artifact", "size", check.size)
+	}
+
+	// The tarball URLs are kept, the mirror serving the bundle points them at itself with -public-url.
+	index, err := zig.FilterArtifacts(raw, func(a zig.Artifact) bool {
+		return exported[a.Tarball]
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to filter index.json: %w", err)
+	}
+	if err := bundle.addData("download/index.json", index); err != nil {
+		return report, err
+	}
+	manifest["download/index.json"] = sha256Hex(index)
+
+	// The dev builds of the bundle are kept by the cleanup of the mirror serving it, like imported ones.
+	if len(devBuilds) > 0 {
+		builds := formatImportedBuilds(devBuilds)
+		if err := bundle.addData(importedBuildsFile, builds); err != nil {
+			return report, err
+		}
+		manifest[importedBuildsFile] = sha256Hex(builds)
+	}
+
+	var sums strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(manifest)) {
+		fmt.Fprintf(&sums, "%s  %s\n", manifest[name], name)
+	}
+	if err := bundle.addData(exportManifest, []byte(sums.String())); err != nil {
+		return report, err
+	}
+
+	return report, bundle.close()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// bundleWriter writes the files of an export bundle, names are slash-separated paths relative to the bundle root.
+type bundleWriter interface {
+	addFile(name, source string) error
+	addData(name string, data []byte) error
+	// close finishes the bundle, abort removes an unfinished one.
+	close() error
+	abort()
+}
+
+// A bundle written to a directory, files are hardlinked from the cache if possible.
+type dirBundle struct {
+	root string
+}
+
+func newDirBundle(root string) (*dirBundle, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &dirBundle{root: root}, nil
+}
+
+func (b *dirBundle) addFile(name, source string) error {
+	destination := filepath.Join(b.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+	_, err := placeFile(source, destination, ImportAuto)
+	return err
+}
+
+func (b *dirBundle) addData(name string, data []byte) error {
+	destination := filepath.Join(b.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(destination, data)
+}
+
+func (b *dirBundle) close() error { return nil }
+
+// A directory bundle is updated file by file, what was written is kept.
+func (b *dirBundle) abort() {}
+
+// A bundle written to a tar archive. It is written to a temporary file first and moved into place once it is complete.
+type tarBundle struct {
+	output  string
+	file    *os.File
+	tw      *tar.Writer
+	modTime time.Time
+	closed  bool
+}
+
+func newTarBundle(output string) (*tarBundle, error) {
+	file, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &tarBundle{output: output, file: file, tw: tar.NewWriter(file), modTime: time.Now()}, nil
+}
+
+func (b *tarBundle) addFile(name, source string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := b.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(b.tw, f)
+	return err
+}
+
+func (b *tarBundle) addData(name string, data []byte) error {
+	if err := b.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: b.modTime}); err != nil {
+		return err
+	}
+	_, err := b.tw.Write(data)
+	return err
+}
+
+func (b *tarBundle) close() error {
+	b.closed = true
+
+	err := b.tw.Close()
+	if err == nil {
+		err = b.file.Sync()
+	}
+	if closeErr := b.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(b.file.Name(), b.output)
+	}
+	if err != nil {
+		os.Remove(b.file.Name())
+	}
+	return err
+}
+
+func (b *tarBundle) abort() {
+	if b.closed {
+		return
+	}
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// Extracts a tar archive into dir.
+func extractTar(t *testing.T, archive, dir string) {
+	t.Helper()
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCacheExport(t *testing.T) {
+	t.Parallel()
+
+	const (
+		content        = "zig tarball"
+		windowsContent = "zig windows zip"
+		aarch64        = "zig-aarch64-linux-0.14.1.tar.xz"
+		windows        = "zig-x86_64-windows-0.14.1.zip"
+	)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sum := func(s string) string {
+			h := sha256.Sum256([]byte(s))
+			return hex.EncodeToString(h[:])
+		}
+		fmt.Fprintf(w, `{
+			"0.14.1": {
+				"version": "0.14.1",
+				"x86_64-linux": {"tarball": "https://ziglang.org/download/0.14.1/%s", "shasum": "%s", "size": "%d"},
+				"aarch64-linux": {"tarball": "https://ziglang.org/download/0.14.1/%s", "shasum": "%s", "size": "%d"},
+				"x86_64-windows": {"tarball": "https://ziglang.org/download/0.14.1/%s", "shasum": "%s", "size": "%d"}
+			},
+			"0.13.0": {
+				"version": "0.13.0",
+				"x86_64-linux": {"tarball": "https://ziglang.org/download/0.13.0/zig-x86_64-linux-0.13.0.tar.xz", "shasum": "abc", "size": "1"}
+			}
+		}`, testArtifact, sum(content), len(content), aarch64, sum(content), len(content), windows, sum(windowsContent), len(windowsContent))
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		name      string
+		output    string
+		platforms []string
+	}{
+		{"directory", "bundle", []string{"x86_64-linux", "aarch64-linux"}},
+		{"tar archive", "bundle.tar", []string{"x86_64-linux", "aarch64-linux"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir := t.TempDir()
+			dir := filepath.Join(cacheDir, "download", "0.14.1")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			// aarch64-linux is not cached, the cached Windows zip is not selected.
+			for name, data := range map[string]string{
+				testArtifact:              content,
+				testArtifact + ".minisig": "signature",
+				windows:                   windowsContent,
+			} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cache := NewCache(CacheOptions{
+				UpstreamHost: upstream.URL,
+				CacheDir:     cacheDir,
+				Index:        zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, ""),
+			})
+
+			output := filepath.Join(t.TempDir(), tt.output)
+			report, err := cache.Export(context.Background(), ExportOptions{
+				Versions:  []string{"0.14.1"},
+				Platforms: tt.platforms,
+				Output:    output,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Artifacts != 1 || len(report.Missing) != 1 || report.Missing[0] != aarch64 {
+				t.Errorf("got %v exported and %v missing artifacts, want 1 and [%v]", report.Artifacts, report.Missing, aarch64)
+			}
+
+			bundle := output
+			if strings.HasSuffix(output, ".tar") {
+				bundle = t.TempDir()
+				extractTar(t, output, bundle)
+			}
+
+			manifest, err := os.ReadFile(filepath.Join(bundle, exportManifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := fmt.Sprintf("%s  download/0.14.1/%s\n", sha256Hex([]byte(content)), testArtifact)
+			if !strings.Contains(string(manifest), expected) || strings.Count(string(manifest), "\n") != 3 {
+				t.Errorf("got manifest %q, want the artifact, its signature and index.json", manifest)
+			}
+
+			index, err := os.ReadFile(filepath.Join(bundle, "download", "index.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			releases, err := zig.ParseReleases(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(releases) != 1 || len(releases["0.14.1"].Platforms) != 1 {
+				t.Errorf("got index.json %s, want only the exported artifact", index)
+			}
+			// The mirror serving the bundle points the upstream URLs at itself with -public-url.
+			if got, want := releases["0.14.1"].Platforms["x86_64-linux"].Tarball, "https://ziglang.org/download/0.14.1/"+testArtifact; got != want {
+				t.Errorf("got tarball %v, want %v", got, want)
+			}
+
+			// Another mirror serves the bundle without an upstream.
+			offline := NewCache(CacheOptions{
+				UpstreamHost: "http://127.0.0.1:1",
+				CacheDir:     bundle,
+				Index:        zig.NewIndex([]string{"http://127.0.0.1:1/download/index.json"}, time.Minute, filepath.Join(bundle, "download", "index.json")),
+				Unlisted:     UnlistedReject,
+			})
+			if status := fetchFromMirror(t, offline, "/download/0.14.1/"+testArtifact, content); status != http.StatusOK {
+				t.Errorf("got status %v from the bundle, want %v", status, http.StatusOK)
+			}
+		})
+	}
+}
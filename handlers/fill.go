@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var errFillCommitted = errors.New("fill is already committed")
+
+// fill is an in-flight upstream download of a single artifact.
+// The download is written to a temporary file which concurrent readers follow while it grows.
+// Once the download is verified the temporary file is atomically moved to its final destination.
+type fill struct {
+	filename  string
+	version   string
+	finalPath string
+	ticket    *fillTicket
+
+	// started is closed once the upstream responded and the temporary file exists, or the fill failed.
+	started chan struct{}
+
+	// fileMu guards file against being closed (committed) while readers use it.
+	fileMu sync.RWMutex
+	file   *os.File
+
+	mu      sync.Mutex
+	size    int64 // expected size, -1 if unknown
+	written int64
+	done    bool
+	err     error
+	clients int           // clients currently following the fill
+	changed chan struct{} // closed and replaced on every state change
+}
+
+func newFill(filename, version, finalPath string) *fill {
+	return &fill{
+		filename:  filename,
+		version:   version,
+		finalPath: finalPath,
+		started:   make(chan struct{}),
+		size:      -1,
+		changed:   make(chan struct{}),
+	}
+}
+
+// Attaches the temporary file the download is written to and marks the fill as started.
+// The first written bytes of the file (e.g. a resumed download) are immediately available to readers.
+func (f *fill) attach(file *os.File, written, size int64) {
+	f.fileMu.Lock()
+	f.file = file
+	f.fileMu.Unlock()
+
+	f.mu.Lock()
+	f.written = written
+	f.size = size
+	f.notifyLocked()
+	f.mu.Unlock()
+
+	close(f.started)
+}
+
+// Write appends downloaded bytes to the temporary file and wakes up the readers.
+func (f *fill) Write(p []byte) (int, error) {
+	f.fileMu.RLock()
+	file := f.file
+	f.fileMu.RUnlock()
+	if file == nil {
+		return 0, errFillCommitted
+	}
+
+	n, err := file.Write(p)
+
+	f.mu.Lock()
+	f.written += int64(n)
+	f.notifyLocked()
+	f.mu.Unlock()
+
+	return n, err
+}
+
+// Closes the temporary file and atomically moves it to its final destination.
+func (f *fill) commit() error {
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	if f.file == nil {
+		return errFillCommitted
+	}
+
+	tmpName := f.file.Name()
+
+	// See: https://pkg.go.dev/os#example-CreateTemp-Suffix
+	// Also: On Linux it is allowed to delete an opened file, while on Windows it is not allowed
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if err := os.MkdirAll(filepath.Dir(f.finalPath), 0775); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, f.finalPath)
+}
+
+// Closes the temporary file without committing it and returns its name.
+func (f *fill) discard() string {
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	if f.file == nil {
+		return ""
+	}
+
+	tmpName := f.file.Name()
+	f.file.Close()
+	f.file = nil
+
+	return tmpName
+}
+
+// Registers a client following the fill.
+func (f *fill) addClient() {
+	f.mu.Lock()
+	f.clients++
+	f.mu.Unlock()
+}
+
+// Unregisters a client and returns the number of clients still following the fill.
+func (f *fill) removeClient() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.clients--
+	return f.clients
+}
+
+// Marks the fill as finished. A nil error means the file was committed to its final destination.
+func (f *fill) finish(err error) {
+	f.mu.Lock()
+	f.done = true
+	f.err = err
+	f.notifyLocked()
+	f.mu.Unlock()
+
+	if !f.isStarted() {
+		close(f.started)
+	}
+}
+
+func (f *fill) notifyLocked() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+type fillState struct {
+	size    int64
+	written int64
+	done    bool
+	err     error
+	changed <-chan struct{}
+}
+
+func (f *fill) state() fillState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return fillState{
+		size:    f.size,
+		written: f.written,
+		done:    f.done,
+		err:     f.err,
+		changed: f.changed,
+	}
+}
+
+// Reports whether readers may already follow the fill.
+func (f *fill) isStarted() bool {
+	select {
+	case <-f.started:
+		return true
+	default:
+		return false
+	}
+}
+
+// Waits until the fill has started streaming, or returns the error it failed with.
+func (f *fill) waitStarted(ctx context.Context) error {
+	select {
+	case <-f.started:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if st := f.state(); st.done && st.err != nil {
+		return st.err
+	}
+	return nil
+}
+
+// Waits until the fill is finished and returns its error.
+func (f *fill) wait(ctx context.Context) error {
+	for {
+		st := f.state()
+		if st.done {
+			return st.err
+		}
+
+		select {
+		case <-st.changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Returns a reader of the whole file that follows the download as it progresses.
+func (f *fill) newReader(ctx context.Context) *fillReader {
+	return &fillReader{ctx: ctx, fill: f}
+}
+
+// fillReader reads a file that is still being downloaded.
+// The final byte is held back until the fill is verified and committed,
+// so a client never receives a complete copy of a file that failed verification.
+type fillReader struct {
+	ctx   context.Context
+	fill  *fill
+	pos   int64
+	final *os.File // the committed file, opened once the fill is done
+}
+
+func (r *fillReader) Read(p []byte) (int, error) {
+	for {
+		st := r.fill.state()
+		if st.done && st.err != nil {
+			return 0, st.err
+		}
+
+		available := st.written
+		if !st.done {
+			available--
+		}
+
+		if r.pos < available {
+			n, err := r.readAt(p[:min(int64(len(p)), available-r.pos)], st.done)
+			r.pos += int64(n)
+			if n > 0 || err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				return n, err
+			}
+		} else if st.done {
+			return 0, io.EOF
+		}
+
+		select {
+		case <-st.changed:
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		}
+	}
+}
+
+func (r *fillReader) readAt(p []byte, committed bool) (int, error) {
+	if committed {
+		if r.final == nil {
+			file, err := os.Open(r.fill.finalPath)
+			if err != nil {
+				return 0, err
+			}
+			r.final = file
+		}
+		return r.final.ReadAt(p, r.pos)
+	}
+
+	r.fill.fileMu.RLock()
+	defer r.fill.fileMu.RUnlock()
+
+	// Committed in the meantime, the caller waits for the state change.
+	if r.fill.file == nil {
+		return 0, nil
+	}
+	return r.fill.file.ReadAt(p, r.pos)
+}
+
+// Closes the committed file if it was opened.
+func (r *fillReader) Close() error {
+	if r.final != nil {
+		return r.final.Close()
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheHandlerStreaming(t *testing.T) {
+	t.Parallel()
+
+	firstHalf := strings.Repeat("a", 64<<10)
+	secondHalf := strings.Repeat("b", 64<<10)
+
+	release := make(chan struct{})
+	var upstreamRequests atomic.Int32
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Content-Length", "131072")
+		w.Write([]byte(firstHalf))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte(secondHalf))
+	}))
+	defer upstream.Close()
+
+	cacheDir := t.TempDir()
+	cache := NewCache(CacheOptions{
+		UpstreamHost: upstream.URL,
+		CacheDir:     cacheDir,
+		Unlisted:     UnlistedAccept,
+	})
+	mirror := httptest.NewServer(cache.Handler())
+	defer mirror.Close()
+
+	const clients = 3
+
+	var wg, gotHead sync.WaitGroup
+	gotHead.Add(clients)
+	for range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			headRead := false
+			defer func() {
+				if !headRead {
+					gotHead.Done()
+				}
+			}()
+
+			resp, err := http.Get(mirror.URL + "/download/0.14.1/" + testArtifact)
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+
+			if resp.ContentLength != 131072 {
+				t.Errorf("got content length %d, want 131072", resp.ContentLength)
+			}
+
+			// The first half (minus the held back byte) is readable while upstream is still stalled.
+			head := make([]byte, len(firstHalf)-1)
+			if _, err := io.ReadFull(resp.Body, head); err != nil {
+				t.Errorf("failed to read the first half: %v", err)
+				return
+			}
+			headRead = true
+			gotHead.Done()
+
+			rest, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("failed to read the second half: %v", err)
+				return
+			}
+			if string(head)+string(rest) != firstHalf+secondHalf {
+				t.Errorf("got unexpected content")
+			}
+		}()
+	}
+
+	// Let upstream finish only once every client got the first half.
+	go func() {
+		gotHead.Wait()
+		close(release)
+	}()
+
+	wg.Wait()
+
+	if got := upstreamRequests.Load(); got != 1 {
+		t.Errorf("got %d upstream requests, want 1", got)
+	}
+
+	if !fileExists(filepath.Join(cacheDir, "download", "0.14.1", testArtifact)) {
+		t.Errorf("file was not cached")
+	}
+}
+
+func TestCacheHandlerClientDisconnect(t *testing.T) {
+	t.Parallel()
+
+	content := strings.Repeat("z", 64<<10)
+	release := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content[:1024]))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte(content[1024:]))
+	}))
+	defer upstream.Close()
+
+	cacheDir := t.TempDir()
+	cache := NewCache(CacheOptions{
+		UpstreamHost: upstream.URL,
+		CacheDir:     cacheDir,
+		Unlisted:     UnlistedAccept,
+	})
+	mirror := httptest.NewServer(cache.Handler())
+	defer mirror.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", mirror.URL+"/download/0.14.1/"+testArtifact, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if _, err := io.ReadFull(resp.Body, make([]byte, 512)); err != nil {
+		t.Fatalf("failed to read the beginning of the file: %v", err)
+	}
+
+	// The only client goes away, the download must carry on.
+	cancel()
+	resp.Body.Close()
+	close(release)
+
+	finalPath := filepath.Join(cacheDir, "download", "0.14.1", testArtifact)
+	deadline := time.Now().Add(5 * time.Second)
+	for !fileExists(finalPath) {
+		if time.Now().After(deadline) {
+			t.Fatal("file was not cached after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCacheFillBaseContextCancel(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	baseCtx, shutdown := context.WithCancel(context.Background())
+	cacheDir := t.TempDir()
+	cache := NewCache(CacheOptions{
+		UpstreamHost: upstream.URL,
+		CacheDir:     cacheDir,
+		Unlisted:     UnlistedAccept,
+		BaseContext:  baseCtx,
+	})
+
+	f, started := cache.startFill(slog.Default(), testArtifact, "0.14.1", priorityInteractive)
+	if f == nil || !started {
+		t.Fatal("expected a new fill to be started")
+	}
+	if err := f.waitStarted(context.Background()); err != nil {
+		t.Fatalf("fill failed to start: %v", err)
+	}
+
+	shutdown()
+
+	if err := f.wait(context.Background()); err == nil {
+		t.Fatal("expected the fill to be cancelled by the base context")
+	}
+	if fileExists(f.finalPath) {
+		t.Error("a cancelled fill must not be cached")
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Returns the IP address of the client.
+// Forwarding headers are only used if the direct peer is one of the trusted proxies,
+// the chain of proxies is walked from right to left and the first untrusted address is the client.
+// The RFC 7239 Forwarded header takes precedence over X-Forwarded-For, X-Real-IP is used if neither is set.
+func clientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	peer, ok := parseNode(r.RemoteAddr)
+	if !ok {
+		return remoteHost(r.RemoteAddr)
+	}
+	if !isTrusted(peer, trustedProxies) {
+		return peer.String()
+	}
+
+	chain, ok := forwardedChain(r.Header)
+	if !ok {
+		if realIP, ok := parseNode(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ok {
+			return realIP.String()
+		}
+		return peer.String()
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseNode(chain[i])
+		if !ok {
+			// An obfuscated or unknown node, the last trusted hop is the best guess.
+			break
+		}
+
+		client = addr
+		if !isTrusted(addr, trustedProxies) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func isTrusted(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the forwarded-for nodes from the Forwarded or, if it is missing, X-Forwarded-For headers,
+// the client first and the nearest proxy last.
+func forwardedChain(header http.Header) ([]string, bool) {
+	var chain []string
+
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range splitQuoted(value, ',') {
+				for _, pair := range splitQuoted(element, ';') {
+					key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+					if found && strings.EqualFold(key, "for") {
+						chain = append(chain, strings.Trim(value, `"`))
+					}
+				}
+			}
+		}
+		return chain, len(chain) > 0
+	}
+
+	for _, value := range header.Values("X-Forwarded-For") {
+		for entry := range strings.SplitSeq(value, ",") {
+			chain = append(chain, strings.TrimSpace(entry))
+		}
+	}
+	return chain, len(chain) > 0
+}
+
+// Splits s by sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	var quoted, escaped bool
+
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Parses an IP address with an optional port, IPv6 addresses with a port are enclosed in brackets.
+func parseNode(node string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(strings.Trim(node, "[]")); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// Returns the host of a host:port address, or the address itself if it has no port.
+func remoteHost(remoteAddr string) string {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// ReadinessOptions holds the settings of the readiness checks.
+type ReadinessOptions struct {
+	// CacheDir must be writable.
+	CacheDir string
+	// MinFreeSpace is the number of bytes that must be available in CacheDir, zero disables the check.
+	MinFreeSpace int64
+	// Index must have been fetched successfully within IndexMaxAge, zero disables the check.
+	Index       *zig.Index
+	IndexMaxAge time.Duration
+	// Draining reports whether the server is shutting down, nil disables the check.
+	Draining func() bool
+}
+
+// Result of a single readiness check, Reason explains a failure.
+type checkResult struct {
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// HealthHandler returns the http.HandlerFunc of the liveness probe.
+// It only reports that the server is able to handle requests.
+func HealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+	}
+}
+
+// ReadinessHandler returns the http.HandlerFunc of the readiness probe.
+// It fails with 503 and the reason of every failed check if the cache directory is not writable,
+// the disk is (almost) full, upstream index.json could not be fetched for too long, or the server is draining.
+func ReadinessHandler(opts ReadinessOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]checkResult{
+			"cache_dir": checkResultOf(checkWritable(opts.CacheDir)),
+		}
+
+		if opts.MinFreeSpace > 0 {
+			checks["free_space"] = checkResultOf(checkFreeSpace(opts.CacheDir, opts.MinFreeSpace))
+		}
+
+		if opts.Index != nil && opts.IndexMaxAge > 0 {
+			checks["index"] = checkResultOf(checkIndex(opts.Index, opts.IndexMaxAge))
+		}
+
+		if opts.Draining != nil {
+			checks["draining"] = checkResultOf(checkDraining(opts.Draining))
+		}
+
+		resp := healthResponse{Status: "ready", Checks: checks}
+		status := http.StatusOK
+
+		for name, check := range checks {
+			if !check.OK {
+				resp.Status = "not ready"
+				status = http.StatusServiceUnavailable
+				slog.Warn("readiness check failed", "check", name, "reason", check.Reason, "remote_ip", GetRemoteIP(*r))
+			}
+		}
+
+		writeHealth(w, status, resp)
+	}
+}
+
+func checkResultOf(err error) checkResult {
+	if err != nil {
+		return checkResult{OK: false, Reason: err.Error()}
+	}
+	return checkResult{OK: true}
+}
+
+// Creates and removes a temporary file in the directory.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*.tmp")
+	if err != nil {
+		return fmt.Errorf("cache directory is not writable: %w", err)
+	}
+	f.Close()
+
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("failed to remove a file from the cache directory: %w", err)
+	}
+	return nil
+}
+
+func checkFreeSpace(dir string, minFreeSpace int64) error {
+	available, err := freeSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		// Nothing to check on this platform.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to determine the free space of the cache directory: %w", err)
+	}
+
+	if available < minFreeSpace {
+		return fmt.Errorf("only %d bytes are available in the cache directory, at least %d are required", available, minFreeSpace)
+	}
+	return nil
+}
+
+// The probe never waits for upstream, a missing or expired index.json is fetched in the background.
+func checkIndex(index *zig.Index, maxAge time.Duration) error {
+	fetchedAt, ok := index.FetchedAt()
+	if !ok {
+		return errors.New("upstream index.json is not loaded yet")
+	}
+
+	if age := time.Since(fetchedAt); age > maxAge {
+		return fmt.Errorf("upstream index.json was last fetched %s ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+func checkDraining(draining func() bool) error {
+	if draining() {
+		return errors.New("the server is shutting down and finishing the downloads in progress")
+	}
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
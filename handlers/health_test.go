@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+
+	rr := httptest.NewRecorder()
+	HealthHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("got status %v, want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"0.14.1": {"version": "0.14.1"}}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(broken.Close)
+
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(hanging.Close)
+	t.Cleanup(func() { close(release) })
+
+	fetched := zig.NewIndex([]string{upstream.URL}, time.Minute, "")
+	if _, err := fetched.Releases(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		opts           func(cacheDir string) ReadinessOptions
+		expectedStatus int
+		failedCheck    string
+	}{
+		{
+			name: "ready",
+			opts: func(cacheDir string) ReadinessOptions {
+				return ReadinessOptions{
+					CacheDir:     cacheDir,
+					MinFreeSpace: 1,
+					Index:        fetched,
+					IndexMaxAge:  time.Hour,
+				}
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "missing cache directory",
+			opts: func(cacheDir string) ReadinessOptions {
+				return ReadinessOptions{CacheDir: filepath.Join(cacheDir, "missing")}
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			failedCheck:    "cache_dir",
+		},
+		{
+			name: "disk is full",
+			opts: func(cacheDir string) ReadinessOptions {
+				return ReadinessOptions{CacheDir: cacheDir, MinFreeSpace: 1 << 62}
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			failedCheck:    "free_space",
+		},
+		{
+			name: "upstream index.json unavailable",
+			opts: func(cacheDir string) ReadinessOptions {
+				return ReadinessOptions{
+					CacheDir:    cacheDir,
+					Index:       zig.NewIndex([]string{broken.URL}, time.Minute, ""),
+					IndexMaxAge: time.Hour,
+				}
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			failedCheck:    "index",
+		},
+		{
+			name: "upstream index.json not loaded yet",
+			opts: func(cacheDir string) ReadinessOptions {
+				return ReadinessOptions{
+					CacheDir:    cacheDir,
+					Index:       zig.NewIndex([]string{hanging.URL}, time.Minute, ""),
+					IndexMaxAge: time.Hour,
+				}
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			failedCheck:    "index",
+		},
+		{
+			name: "draining",
+			opts: func(cacheDir string) ReadinessOptions {
+				return ReadinessOptions{CacheDir: cacheDir, Draining: func() bool { return true }}
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			failedCheck:    "draining",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rr := httptest.NewRecorder()
+			start := time.Now()
+			ReadinessHandler(tt.opts(t.TempDir())).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("got a response after %v, want it without waiting for upstream", elapsed)
+			}
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("got status %v, want %v", rr.Code, tt.expectedStatus)
+			}
+
+			var resp healthResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("got invalid JSON %q: %v", rr.Body.String(), err)
+			}
+
+			for name, check := range resp.Checks {
+				if check.OK != (name != tt.failedCheck) {
+					t.Errorf("got check %v %+v, want only %q to fail", name, check, tt.failedCheck)
+				}
+				if !check.OK && check.Reason == "" {
+					t.Errorf("got failed check %v without a reason", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/savalione/go-mirror-zig/internal/atomicfile"
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// ImportMethod is how imported files are placed in the cache.
+type ImportMethod string
+
+const (
+	// ImportAuto tries a hardlink, then a reflink, then a copy.
+	ImportAuto     ImportMethod = "auto"
+	ImportHardlink ImportMethod = "hardlink"
+	ImportReflink  ImportMethod = "reflink"
+	ImportCopy     ImportMethod = "copy"
+)
+
+// Results of importing a file.
+const (
+	ImportStatusImported = "imported"
+	ImportStatusReplaced = "replaced" // a different cached file was replaced by the verified import
+	ImportStatusPresent  = "present"  // an identical file is already cached
+	ImportStatusRejected = "rejected"
+	ImportStatusFailed   = "failed"
+)
+
+// ImportResult is the outcome of importing a single file.
+type ImportResult struct {
+	Source      string
+	Destination string
+	Status      string
+	Method      ImportMethod
+	Size        int64
+	Reason      string
+}
+
+// ImportReport summarizes an import.
+type ImportReport struct {
+	Imported int
+	Replaced int
+	Present  int
+	Rejected int
+	Failed   int
+	// Ignored counts the files that are not Zig artifacts.
+	Ignored       int
+	ImportedBytes int64
+	Results       []ImportResult
+}
+
+// Import places the Zig artifacts in the provided files and directories into the cache, directories are walked recursively.
+// Every artifact is verified against index.json and its signature like a download, and its .minisig is imported with it.
+// Artifacts that are already cached are skipped, so an interrupted import can simply be run again.
+func (c *Cache) Import(ctx context.Context, sources []string, method ImportMethod) (ImportReport, error) {
+	var report ImportReport
+
+	var releases zig.ZigReleases
+	if c.index != nil {
+		var err error
+		if releases, err = c.index.Releases(ctx); err != nil {
+			slog.Warn("failed to fetch index.json for the import, only signatures are verified", "error", err)
+		}
+	}
+
+	for _, source := range sources {
+		err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			// Signatures are imported together with their artifacts.
+			name := d.Name()
+			if strings.HasSuffix(name, ".minisig") {
+				return nil
+			}
+			if !zig.IsZigArtifact(name) {
+				slog.Debug("ignoring a file that is not a Zig artifact", "source", path)
+				report.Ignored++
+				return nil
+			}
+
+			// Symlinks are resolved, so that a hardlink points at the file and not at the link.
+			if d.Type()&fs.ModeSymlink != 0 {
+				resolved, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					slog.Error("failed to resolve a symlink to import", "source", path, "error", err)
+					report.add(ImportResult{Source: path, Status: ImportStatusFailed, Reason: err.Error()})
+					return nil
+				}
+				path = resolved
+			} else if !d.Type().IsRegular() {
+				report.Ignored++
+				return nil
+			}
+
+			result := c.importFile(ctx, path, releases, method)
+			report.add(result)
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (r *ImportReport) add(result ImportResult) {
+	switch result.Status {
+	case ImportStatusImported:
+		r.Imported++
+		r.ImportedBytes += result.Size
+	case ImportStatusReplaced:
+		r.Replaced++
+		r.ImportedBytes += result.Size
+	case ImportStatusPresent:
+		r.Present++
+	case ImportStatusRejected:
+		r.Rejected++
+	default:
+		r.Failed++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// Verifies and imports a single artifact and its signature.
+func (c *Cache) importFile(ctx context.Context, source string, releases zig.ZigReleases, method ImportMethod) ImportResult {
+	filename := filepath.Base(source)
+	result := ImportResult{Source: source}
+
+	submatches := zig.ArtifactSubmatches(filename)
+	if submatches == nil {
+		result.Status, result.Reason = ImportStatusRejected, "not a Zig artifact"
+		return result
+	}
+	result.Destination = filepath.Join(c.destinationDir(submatches[1]), filename)
+
+	logger := slog.With("source", source, "destination", result.Destination)
+
+	fail := func(err error) ImportResult {
+		result.Status, result.Reason = ImportStatusFailed, err.Error()
+		logger.Error("failed to import an artifact", "error", err)
+		return result
+	}
+	reject := func(reason string) ImportResult {
+		result.Status, result.Reason = ImportStatusRejected, reason
+		logger.Error("refusing to import an artifact", "reason", reason)
+		return result
+	}
+
+	check, err := c.checkFile(ctx, source, source+".minisig", 0)
+	if err != nil {
+		return fail(err)
+	}
+	result.Size = check.size
+
+	artifact, listed := releases.FindArtifact(filename)
+	listed = listed && artifact.Shasum != ""
+
+	switch {
+	case listed && artifact.Size != "" && artifact.Size != strconv.FormatInt(check.size, 10):
+		return reject(fmt.Sprintf("size is %d bytes, index.json lists %s bytes", check.size, artifact.Size))
+	case listed && check.sha256 != strings.ToLower(artifact.Shasum):
+		return reject("checksum does not match index.json")
+	case check.signature == signatureInvalid:
+		return reject("signature verification failed")
+	case !listed && check.signature != signatureValid && c.unlisted == UnlistedReject:
+		return reject("not listed in index.json and not signed")
+	case !listed && check.signature != signatureValid:
+		logger.Warn("artifact is not listed in index.json and not signed, importing it without verification")
+	}
+	verified := listed || check.signature == signatureValid
+
+	result.Status = ImportStatusImported
+	if cached, err := os.Stat(result.Destination); err == nil {
+		sourceInfo, err := os.Stat(source)
+		if err != nil {
+			return fail(err)
+		}
+
+		identical := os.SameFile(sourceInfo, cached)
+		if !identical && cached.Size() == check.size {
+			cachedCheck, err := c.checkFile(ctx, result.Destination, "", 0)
+			if err != nil {
+				return fail(err)
+			}
+			identical = cachedCheck.sha256 == check.sha256
+		}
+
+		switch {
+		case identical:
+			result.Status = ImportStatusPresent
+		case !verified:
+			return reject("a different file with the same name is already cached")
+		default:
+			logger.Warn("replacing a cached artifact that differs from the verified import")
+			result.Status = ImportStatusReplaced
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fail(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(result.Destination), 0755); err != nil {
+		return fail(err)
+	}
+
+	if result.Status != ImportStatusPresent {
+		err := c.evictor.track(func() (err error) {
+			result.Method, err = placeFile(source, result.Destination, method)
+			return err
+		}, result.Destination)
+		if err != nil {
+			return fail(err)
+		}
+		logger.Info("imported an artifact", "method", result.Method, "size", check.size)
+	}
+
+	// Signatures that failed verification were rejected above, the others are cached like downloaded ones.
+	if check.signature != signatureMissing && check.signature != signatureInvalid {
+		signature := result.Destination + ".minisig"
+		if err := c.evictor.track(func() error { return importSignature(source+".minisig", signature) }, signature); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Imported dev builds are kept by the cleanup of stale dev builds.
+	if strings.Contains(submatches[1], "-dev") {
+		if err := c.addImportedBuilds(filename); err != nil {
+			return fail(err)
+		}
+	}
+
+	return result
+}
+
+// Copies a signature next to its imported artifact unless an identical one is already there.
+func importSignature(source, destination string) error {
+	data, err := os.ReadFile(source)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if cached, err := os.ReadFile(destination); err == nil && string(cached) == string(data) {
+		return nil
+	}
+	return atomicfile.WriteFile(destination, data)
+}
+
+// Places a file at destination with the provided method, and returns the method that was used.
+// The file is prepared under a temporary name first and atomically moved into place.
+func placeFile(source, destination string, method ImportMethod) (ImportMethod, error) {
+	methods := []ImportMethod{method}
+	if method == ImportAuto {
+		methods = []ImportMethod{ImportHardlink, ImportReflink, ImportCopy}
+	}
+
+	var errs []error
+	for _, m := range methods {
+		tmpName, err := reserveTempName(destination)
+		if err != nil {
+			return m, err
+		}
+
+		switch m {
+		case ImportHardlink:
+			err = os.Link(source, tmpName)
+		case ImportReflink:
+			err = reflink(source, tmpName)
+		default:
+			err = copyFile(source, tmpName)
+		}
+
+		if err == nil {
+			if err = os.Rename(tmpName, destination); err == nil {
+				return m, nil
+			}
+		}
+		os.Remove(tmpName)
+
+		slog.Debug("failed to place an imported file", "method", m, "destination", destination, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", m, err))
+	}
+
+	return method, errors.Join(errs...)
+}
+
+// Returns an unused temporary name next to name, the file itself doesn't exist.
+func reserveTempName(name string) (string, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpFile.Close()
+	return tmpFile.Name(), os.Remove(tmpFile.Name())
+}
+
+// Copies source to a new file at destination.
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+func TestCacheImport(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := zig.ParsePublicKey(base64.StdEncoding.EncodeToString(
+		bytes.Join([][]byte{[]byte("Ed"), {1, 2, 3, 4, 5, 6, 7, 8}, pub}, nil),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const devBuild = "zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz"
+
+	tests := []struct {
+		name           string
+		filename       string
+		content        string
+		signedContent  string // empty means no signature
+		cachedContent  string // empty means not cached yet
+		unlisted       UnlistedPolicy
+		method         ImportMethod
+		expectedStatus string
+		expectedPath   string // relative to the cache directory, empty means not imported
+	}{
+		{"listed artifact", testArtifact, "zig tarball", "zig tarball", "", UnlistedReject, ImportCopy, ImportStatusImported, "download/0.14.1/" + testArtifact},
+		{"hardlink", testArtifact, "zig tarball", "", "", UnlistedReject, ImportHardlink, ImportStatusImported, "download/0.14.1/" + testArtifact},
+		{"already cached", testArtifact, "zig tarball", "", "zig tarball", UnlistedReject, ImportAuto, ImportStatusPresent, "download/0.14.1/" + testArtifact},
+		{"corrupt cached copy", testArtifact, "zig tarball", "", "zig tarbalL", UnlistedReject, ImportAuto, ImportStatusReplaced, "download/0.14.1/" + testArtifact},
+		{"checksum mismatch", testArtifact, "zig tarbalL", "", "", UnlistedReject, ImportAuto, ImportStatusRejected, ""},
+		{"invalid signature", testArtifact, "zig tarball", "other tarball", "", UnlistedReject, ImportAuto, ImportStatusRejected, ""},
+		{"signed dev build", devBuild, "zig dev tarball", "zig dev tarball", "", UnlistedReject, ImportAuto, ImportStatusImported, "builds/" + devBuild},
+		{"unsigned dev build rejected", devBuild, "zig dev tarball", "", "", UnlistedReject, ImportAuto, ImportStatusRejected, ""},
+		{"unsigned dev build accepted", devBuild, "zig dev tarball", "", "", UnlistedAccept, ImportAuto, ImportStatusImported, "builds/" + devBuild},
+		{"unverified conflict", devBuild, "zig dev tarball", "", "other dev tarball", UnlistedAccept, ImportAuto, ImportStatusRejected, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			upstream := newTestUpstream(t, "", "zig tarball")
+			cacheDir := t.TempDir()
+			sourceDir := t.TempDir()
+
+			writeFile := func(path, content string) {
+				t.Helper()
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			source := filepath.Join(sourceDir, "nested", tt.filename)
+			writeFile(source, tt.content)
+			if tt.signedContent != "" {
+				writeFile(source+".minisig", string(testSign(t, priv, []byte(tt.signedContent), tt.filename)))
+			}
+			writeFile(filepath.Join(sourceDir, "README.txt"), "not an artifact")
+
+			cache := NewCache(CacheOptions{
+				UpstreamHost: upstream.URL,
+				CacheDir:     cacheDir,
+				Index:        zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, ""),
+				Unlisted:     tt.unlisted,
+				PublicKey:    &publicKey,
+			})
+
+			cached := filepath.Join(cache.destinationDir(zig.ArtifactSubmatches(tt.filename)[1]), tt.filename)
+			if tt.cachedContent != "" {
+				writeFile(cached, tt.cachedContent)
+			}
+
+			report, err := cache.Import(context.Background(), []string{sourceDir}, tt.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Results) != 1 || report.Ignored != 1 {
+				t.Fatalf("got %v results and %v ignored files, want 1 and 1", len(report.Results), report.Ignored)
+			}
+			if got := report.Results[0].Status; got != tt.expectedStatus {
+				t.Fatalf("got status %v (%v), want %v", got, report.Results[0].Reason, tt.expectedStatus)
+			}
+
+			if tt.expectedPath == "" {
+				if tt.cachedContent == "" && fileExists(cached) {
+					t.Errorf("got a cached file for a rejected import")
+				}
+				return
+			}
+
+			path := filepath.Join(cacheDir, filepath.FromSlash(tt.expectedPath))
+			if data, err := os.ReadFile(path); err != nil || string(data) != tt.content {
+				t.Errorf("got cached content %q (%v), want %q", data, err, tt.content)
+			}
+			if signed := fileExists(path + ".minisig"); signed != (tt.signedContent != "") {
+				t.Errorf("got cached signature %v, want %v", signed, tt.signedContent != "")
+			}
+			builds, err := cache.ImportedBuilds()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := builds[tt.filename], strings.HasPrefix(tt.expectedPath, "builds/"); got != want {
+				t.Errorf("got imported dev build %v, want %v", got, want)
+			}
+			if tt.method == ImportHardlink {
+				sourceInfo, _ := os.Stat(source)
+				cachedInfo, _ := os.Stat(path)
+				if !os.SameFile(sourceInfo, cachedInfo) {
+					t.Errorf("got a copy, want a hardlink")
+				}
+			}
+
+			// A second run finds everything in place.
+			report, err = cache.Import(context.Background(), []string{sourceDir}, tt.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Present != 1 {
+				t.Errorf("got %v already cached artifacts on the second run, want 1", report.Present)
+			}
+		})
+	}
+}
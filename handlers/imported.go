@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/savalione/go-mirror-zig/internal/atomicfile"
+)
+
+// Lists the dev builds that were imported or came with an export bundle, one file name per line.
+// Upstream drops dev builds from index.json after a while, and these may not be available anywhere else,
+// so the cleanup of stale dev builds keeps them.
+const importedBuildsFile = "imported-builds.txt"
+
+// ImportedBuilds returns the file names of the dev builds that were placed in the cache by -import or came with an export bundle.
+func (c *Cache) ImportedBuilds() (map[string]bool, error) {
+	data, err := os.ReadFile(filepath.Join(c.cacheDir, importedBuildsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseImportedBuilds(data), nil
+}
+
+// Adds dev builds to the list of imported ones.
+func (c *Cache) addImportedBuilds(filenames ...string) error {
+	builds, err := c.ImportedBuilds()
+	if err != nil {
+		return err
+	}
+
+	added := false
+	for _, filename := range filenames {
+		if !builds[filename] {
+			builds[filename] = true
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+
+	return atomicfile.WriteFile(filepath.Join(c.cacheDir, importedBuildsFile), formatImportedBuilds(builds))
+}
+
+func parseImportedBuilds(data []byte) map[string]bool {
+	builds := make(map[string]bool)
+	for line := range strings.Lines(string(data)) {
+		if filename := strings.TrimSpace(line); filename != "" {
+			builds[filename] = true
+		}
+	}
+	return builds
+}
+
+func formatImportedBuilds(builds map[string]bool) []byte {
+	var b strings.Builder
+	for _, filename := range slices.Sorted(maps.Keys(builds)) {
+		b.WriteString(filename + "\n")
+	}
+	return []byte(b.String())
+}
@@ -7,7 +7,9 @@ import (
 	"runtime"
 )
 
-func RootHandler(t *template.Template, version string) http.HandlerFunc {
+// RootHandler returns the http.HandlerFunc of the built-in index page.
+// offline tells the page that missing files can't be downloaded from upstream.
+func RootHandler(t *template.Template, version string, offline bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
 			http.NotFound(w, r)
@@ -17,9 +19,11 @@ func RootHandler(t *template.Template, version string) http.HandlerFunc {
 		info := struct {
 			Version      string
 			Architecture string
+			Offline      bool
 		}{
 			Version:      version,
 			Architecture: runtime.GOARCH,
+			Offline:      offline,
 		}
 
 		if version == "" {
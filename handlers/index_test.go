@@ -14,6 +14,7 @@ func TestRootHandler(t *testing.T) {
 		name           string
 		uri            string
 		version        string
+		offline        bool
 		expectedStatus int
 		expectedBody   string
 		template       *template.Template
@@ -41,6 +42,15 @@ func TestRootHandler(t *testing.T) {
 			expectedBody:   "<b>version unknown</b>",
 			template:       template.Must(template.New("index.html").Parse("<b>version {{ .Version }}</b>")),
 		},
+		{
+			name:           "Offline mirror",
+			uri:            "/",
+			version:        "1.2.3",
+			offline:        true,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<b>offline</b>",
+			template:       template.Must(template.New("index.html").Parse("<b>{{ if .Offline }}offline{{ else }}online{{ end }}</b>")),
+		},
 	}
 
 	for _, tt := range tests {
@@ -50,7 +60,7 @@ func TestRootHandler(t *testing.T) {
 			req := httptest.NewRequest("GET", tt.uri, nil)
 			rr := httptest.NewRecorder()
 
-			handler := RootHandler(tt.template, tt.version)
+			handler := RootHandler(tt.template, tt.version, tt.offline)
 			handler.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tt.expectedStatus {
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || dragonfly || netbsd || openbsd
+
+package handlers
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Takes an exclusive lock on an open file without waiting, it is released when the file is closed.
+func lockFile(file *os.File) error {
+	err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errFileLocked
+	}
+	return err
+}
+
+// Removes a lock file while it is still locked, so that nobody takes a lock on it in between.
+func unlockFile(file *os.File) {
+	os.Remove(file.Name())
+	file.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || dragonfly || netbsd || openbsd || windows)
+
+package handlers
+
+import "os"
+
+// File locks are not supported on this platform, only the fills of this process are coordinated.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) {
+	os.Remove(file.Name())
+	file.Close()
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Takes an exclusive lock on an open file without waiting, it is released when the file is closed.
+func lockFile(file *os.File) error {
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &windows.Overlapped{})
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errFileLocked
+	}
+	return err
+}
+
+// Removes a lock file after unlocking it.
+// Open files can't be removed on Windows, so the file stays if another process opened it in the meantime.
+func unlockFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
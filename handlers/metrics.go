@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/savalione/go-mirror-zig/internal/metrics"
+)
+
+// A response writer that counts the bytes of the response body.
+type countingWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the io.ReaderFrom of the underlying response writer,
+// which sends streamed files right away instead of buffering them.
+func (w *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.written += n
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying response writer.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Records the cache result (hit, miss or coalesced) of a request for an artifact.
+// Clients can request any file name, so only cached and listed artifacts get their platform and version labels,
+// all other ones share "other" and don't add a series per file name.
+func (c *Cache) recordRequest(r *http.Request, result, filename string) {
+	channel, platform, version := metrics.ArtifactLabels(filename)
+	if result != "hit" && !c.listed(filename) {
+		platform, version = "other", "other"
+	}
+	c.metrics.CacheRequests.Inc(result, channel, platform, version)
+	setCacheStatus(r, strings.ToUpper(result))
+}
+
+// Reports whether an artifact or signature is listed in the cached index.json.
+// It never waits for upstream, nothing is listed until index.json was loaded.
+func (c *Cache) listed(filename string) bool {
+	if c.index == nil {
+		return false
+	}
+	_, ok := c.index.Cached().FindArtifact(strings.TrimSuffix(filename, ".minisig"))
+	return ok
+}
+
+func (c *Cache) recordUpstreamResponse(sourceURL string, statusCode int) {
+	c.metrics.UpstreamResponses.Inc(upstreamLabel(sourceURL), strconv.Itoa(statusCode))
+}
+
+// Returns the host of an upstream URL, the paths of the files would make a label per file.
+func upstreamLabel(sourceURL string) string {
+	u, err := url.Parse(sourceURL)
+	if err != nil {
+		return "unknown"
+	}
+	return u.Host
+}
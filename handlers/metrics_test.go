@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/metrics"
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+func TestCacheMetrics(t *testing.T) {
+	t.Parallel()
+
+	content := "zig tarball"
+	upstream := newTestUpstream(t, content, content)
+	m := metrics.New()
+
+	// Requests are labeled with the index.json that is already loaded.
+	index := zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, "")
+	if _, err := index.Releases(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache(CacheOptions{
+		UpstreamHost: upstream.URL,
+		CacheDir:     t.TempDir(),
+		Index:        index,
+		Unlisted:     UnlistedReject,
+		Metrics:      m,
+	})
+
+	for range 2 {
+		if status := fetchFromMirror(t, cache, "/download/0.14.1/"+testArtifact, content); status != http.StatusOK {
+			t.Fatalf("got status %v, want %v", status, http.StatusOK)
+		}
+	}
+
+	// Files that are neither cached nor listed don't get their own series.
+	for _, version := range []string{"0.0.1", "0.0.2"} {
+		if status := fetchFromMirror(t, cache, "/download/"+version+"/zig-x86_64-linux-"+version+".tar.xz", ""); status != http.StatusNotFound {
+			t.Fatalf("got status %v, want %v", status, http.StatusNotFound)
+		}
+	}
+
+	upstreamHost := upstreamLabel(upstream.URL)
+
+	tests := []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{"misses", m.CacheRequests.Value("miss", "stable", "x86_64-linux", "0.14.1"), 1},
+		{"hits", m.CacheRequests.Value("hit", "stable", "x86_64-linux", "0.14.1"), 1},
+		{"misses of unknown files", m.CacheRequests.Value("miss", "stable", "other", "other"), 2},
+		{"misses of an unknown version", m.CacheRequests.Value("miss", "stable", "x86_64-linux", "0.0.1"), 0},
+		{"bytes served from the fill", m.ServedBytes.Value("fill"), float64(len(content))},
+		{"bytes served from the cache", m.ServedBytes.Value("cache"), float64(len(content))},
+		{"bytes downloaded", m.FillBytes.Value(upstreamHost), float64(len(content))},
+		{"upstream responses", m.UpstreamResponses.Value(upstreamHost, "200"), 1},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.expected {
+			t.Errorf("got %v %v, want %v", tt.got, tt.name, tt.expected)
+		}
+	}
+
+	// The cache size follows the fills and removals without walking the cache directory.
+	for _, tt := range []struct {
+		change   func()
+		expected string
+	}{
+		{func() {}, "zigmirror_cache_size_bytes 11"},
+		{func() { cache.Remove(filepath.Join(cache.cacheDir, "download", "0.14.1", testArtifact)) }, "zigmirror_cache_size_bytes 0"},
+	} {
+		tt.change()
+
+		rr := httptest.NewRecorder()
+		m.Registry.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		if body := rr.Body.String(); !containsLine(body, tt.expected) {
+			t.Errorf("got metrics\n%s\nwant %q", body, tt.expected)
+		}
+	}
+}
+
+func containsLine(s, line string) bool {
+	for l := range strings.Lines(s) {
+		if strings.TrimSuffix(l, "\n") == line {
+			return true
+		}
+	}
+	return false
+}
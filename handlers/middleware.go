@@ -1,14 +1,14 @@
 package handlers
 
 import (
-	"net"
 	"net/http"
-	"strings"
+	"net/netip"
+	"time"
 )
 
-// A custom response writer to capture the status code.
+// A custom response writer to capture the status code and, through countingWriter, the size of the response body.
 type statusRecorder struct {
-	http.ResponseWriter
+	countingWriter
 	status int
 }
 
@@ -17,27 +17,68 @@ func (r *statusRecorder) WriteHeader(status int) {
 	r.ResponseWriter.WriteHeader(status)
 }
 
-func Middleware(next http.Handler) http.Handler {
+// MiddlewareOptions holds the settings of the middleware.
+type MiddlewareOptions struct {
+	// AccessLog writes a line for every request, nil disables it.
+	AccessLog *AccessLogger
+	// TrustedProxies are the networks of the reverse proxies whose forwarding headers are used to determine the client IP.
+	TrustedProxies []netip.Prefix
+	// RateLimiter limits the requests of every client, nil disables it.
+	RateLimiter *RateLimiter
+	// WriteTimeouts sets the write deadlines of the responses, nil leaves them to the http.Server.
+	WriteTimeouts *WriteTimeouts
+}
+
+func Middleware(next http.Handler, opts MiddlewareOptions) http.Handler {
+	accessLog := opts.AccessLog
+	if opts.RateLimiter != nil {
+		next = opts.RateLimiter.wrap(next)
+	}
+	// Throttled responses write through the deadlines, so that every chunk extends them.
+	if opts.WriteTimeouts != nil {
+		next = opts.WriteTimeouts.wrap(next)
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
 		// Limit request body size
 		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
 
-		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		recorder := &statusRecorder{countingWriter: countingWriter{ResponseWriter: w}, status: http.StatusOK}
+		r, info := withRequestInfo(r, clientIP(r, opts.TrustedProxies))
+
+		if accessLog != nil {
+			// Aborted responses (http.ErrAbortHandler) are logged too.
+			defer func() {
+				accessLog.log(accessLogEntry{
+					Time:        start,
+					Method:      r.Method,
+					Path:        r.URL.Path,
+					Protocol:    r.Proto,
+					Status:      recorder.status,
+					Bytes:       recorder.written,
+					DurationMs:  float64(time.Since(start).Microseconds()) / 1000,
+					RemoteIP:    GetRemoteIP(*r),
+					Source:      GetSource(*r),
+					UserAgent:   r.UserAgent(),
+					Referer:     r.Referer(),
+					CacheStatus: info.getCacheStatus(),
+					requestURI:  r.URL.RequestURI(),
+				})
+			}()
+		}
 
 		next.ServeHTTP(recorder, r)
 	})
 }
 
+// GetRemoteIP returns the client IP determined by the Middleware, or the IP of the direct peer outside of it.
 func GetRemoteIP(r http.Request) string {
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		return strings.Split(forwarded, ",")[0]
-	}
-
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.remoteIP
 	}
-	return ip
+	return remoteHost(r.RemoteAddr)
 }
 
 func GetSource(r http.Request) string {
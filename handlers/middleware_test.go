@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"strings"
 	"testing"
 )
@@ -13,7 +14,7 @@ func TestMiddleware(t *testing.T) {
 		w.Write([]byte("ok"))
 	})
 
-	middleware := Middleware(nextHandler)
+	middleware := Middleware(nextHandler, MiddlewareOptions{})
 	rr := httptest.NewRecorder()
 
 	req := httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", 1024)))
@@ -27,30 +28,52 @@ func TestMiddleware(t *testing.T) {
 func TestGetRemoteIp(t *testing.T) {
 	t.Parallel()
 
+	trustedProxies := []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.1/32"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+	}
+
 	tests := []struct {
 		name       string
 		remoteAddr string
-		xForwarded string
+		header     http.Header
 		want       string
 	}{
-		{"Direct connection", "1.2.3.4:5678", "", "1.2.3.4"},
-		{"Forwarded", "127.0.0.1:80", "200.200.200.200, 1.1.1.1", "200.200.200.200"},
-		{"Malformed remote addr", "invalid-addr", "", "invalid-addr"},
+		{"Direct connection", "1.2.3.4:5678", nil, "1.2.3.4"},
+		{"Malformed remote addr", "invalid-addr", nil, "invalid-addr"},
+		{"Untrusted peer", "1.2.3.4:5678", http.Header{"X-Forwarded-For": {"200.200.200.200"}}, "1.2.3.4"},
+		{"Forwarded", "127.0.0.1:80", http.Header{"X-Forwarded-For": {"200.200.200.200"}}, "200.200.200.200"},
+		{"Spoofed chain", "127.0.0.1:80", http.Header{"X-Forwarded-For": {"6.6.6.6, 200.200.200.200"}}, "200.200.200.200"},
+		{"Chain of trusted proxies", "127.0.0.1:80", http.Header{"X-Forwarded-For": {"6.6.6.6, 200.200.200.200, 10.1.2.3"}}, "200.200.200.200"},
+		{"Multiple header lines", "127.0.0.1:80", http.Header{"X-Forwarded-For": {"6.6.6.6", "200.200.200.200, 10.1.2.3"}}, "200.200.200.200"},
+		{"Only trusted proxies", "127.0.0.1:80", http.Header{"X-Forwarded-For": {"10.1.2.3"}}, "10.1.2.3"},
+		{"Unknown node", "127.0.0.1:80", http.Header{"X-Forwarded-For": {"unknown, 10.1.2.3"}}, "10.1.2.3"},
+		{"RFC 7239", "127.0.0.1:80", http.Header{"Forwarded": {`for=6.6.6.6, for="[2001:db8::17]:4711";proto=https`}}, "2001:db8::17"},
+		{"RFC 7239 takes precedence", "[::1]:80", http.Header{"Forwarded": {"for=200.200.200.200:1234;by=10.0.0.1"}, "X-Forwarded-For": {"6.6.6.6"}}, "200.200.200.200"},
+		{"X-Real-IP", "127.0.0.1:80", http.Header{"X-Real-Ip": {"200.200.200.200"}}, "200.200.200.200"},
+		{"Untrusted X-Real-IP", "1.2.3.4:80", http.Header{"X-Real-Ip": {"200.200.200.200"}}, "1.2.3.4"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			req := &http.Request{
-				Header:     http.Header{"X-Forwarded-For": []string{tt.xForwarded}},
-				RemoteAddr: tt.remoteAddr,
-			}
-			if got := GetRemoteIP(*req); got != tt.want {
+
+			var got string
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = GetRemoteIP(*r)
+			}), MiddlewareOptions{TrustedProxies: trustedProxies})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header = tt.header
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
 				t.Errorf("got %v, want %v", got, tt.want)
 			}
 		})
 	}
-
 }
 
 func TestGetSource(t *testing.T) {
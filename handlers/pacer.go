@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"time"
+)
+
+// pacer spreads a transfer over time so that it stays within a rate in bytes per second.
+type pacer struct {
+	rate  int64
+	start time.Time
+	done  int64 // bytes transferred so far
+}
+
+func newPacer(rate int64) pacer {
+	return pacer{rate: rate, start: time.Now()}
+}
+
+// Waits until the bytes transferred so far are within the rate, or until ctx is done.
+func (p *pacer) wait(ctx context.Context) error {
+	due := p.start.Add(time.Duration(float64(p.done) / float64(p.rate) * float64(time.Second)))
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Records transferred bytes.
+func (p *pacer) add(n int) {
+	p.done += int64(n)
+}
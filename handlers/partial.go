@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/atomicfile"
+)
+
+// Interrupted downloads are kept in the cache directory under a deterministic name,
+// next to a small JSON file with the upstream validators needed to resume them.
+// The lock file guards both against other processes that use the same cache directory, e.g. -prefetch or -import.
+const (
+	partialSuffix     = ".partial"
+	partialMetaSuffix = ".partial.json"
+	partialLockSuffix = ".partial.lock"
+)
+
+// Interval of the checks whether another process released the lock of a partial download.
+const partialLockPoll = 250 * time.Millisecond
+
+var errFileLocked = errors.New("file is locked by another process")
+
+// partialMeta describes the upstream file a partial download belongs to.
+type partialMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Builds the validators of a partial download from an upstream response.
+func newPartialMeta(sourceURL string, resp *http.Response) partialMeta {
+	meta := partialMeta{
+		URL:          sourceURL,
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	// Only strong validators can be used with If-Range.
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		meta.ETag = etag
+	}
+
+	return meta
+}
+
+// Returns the value for the If-Range header, or an empty string if the partial download can't be resumed safely.
+func (m partialMeta) ifRange() string {
+	if m.ETag != "" {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// Reports whether a 206 response belongs to the same version of the file as the partial download.
+func (m partialMeta) matches(resp *http.Response) bool {
+	if m.ETag != "" {
+		return resp.Header.Get("ETag") == m.ETag
+	}
+	return resp.Header.Get("Last-Modified") == m.LastModified
+}
+
+func (c *Cache) partialPath(filename string) string {
+	return filepath.Join(c.cacheDir, filename+partialSuffix)
+}
+
+// Loads the partial download of a file and returns its validators and size.
+// A zero size means there is nothing that could be resumed.
+func (c *Cache) loadPartial(logger *slog.Logger, filename, sourceURL string) (partialMeta, int64) {
+	partial := c.partialPath(filename)
+
+	info, err := os.Stat(partial)
+	if err != nil || info.Size() == 0 {
+		return partialMeta{}, 0
+	}
+
+	var meta partialMeta
+	data, err := os.ReadFile(partial + ".json")
+	if err == nil {
+		err = json.Unmarshal(data, &meta)
+	}
+
+	if err != nil || meta.URL != sourceURL || meta.ifRange() == "" {
+		logger.Info("discarding a partial download that can't be resumed", "partial_file", partial)
+		c.removePartial(logger, filename)
+		return partialMeta{}, 0
+	}
+
+	return meta, info.Size()
+}
+
+// Returns the upstream URL of the partial download of a file, or an empty string if there is none.
+func (c *Cache) partialURL(filename string) string {
+	var meta partialMeta
+	data, err := os.ReadFile(c.partialPath(filename) + ".json")
+	if err != nil || json.Unmarshal(data, &meta) != nil {
+		return ""
+	}
+	return meta.URL
+}
+
+// Stores the validators of a partial download.
+func (c *Cache) savePartialMeta(filename string, meta partialMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(c.partialPath(filename)+".json", data)
+}
+
+// Removes a partial download and its validators.
+func (c *Cache) removePartial(logger *slog.Logger, filename string) {
+	partial := c.partialPath(filename)
+	c.removeTemp(logger, partial)
+	c.removeTemp(logger, partial+".json")
+}
+
+// Takes the lock of the partial download of a file, which is shared by all processes using the cache directory.
+// If another process holds the lock, it waits until the lock is released or ctx ends.
+// The lock is polled, a blocking lock couldn't be given up once ctx ends.
+func (c *Cache) lockPartial(ctx context.Context, logger *slog.Logger, filename string) (*os.File, error) {
+	name := c.partialPath(filename) + ".lock"
+	if err := os.MkdirAll(c.cacheDir, 0775); err != nil {
+		return nil, err
+	}
+
+	waiting := false
+	for {
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0664)
+		if err != nil {
+			return nil, err
+		}
+
+		err = lockFile(file)
+		if errors.Is(err, errFileLocked) {
+			file.Close()
+			if !waiting {
+				logger.Info("another process is downloading the file into the cache directory, waiting for it")
+				waiting = true
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(partialLockPoll):
+			}
+			continue
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		// The previous holder removed the file before releasing the lock, a lock on a removed file guards nothing.
+		locked, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(name); err == nil && os.SameFile(locked, current) {
+			return file, nil
+		}
+		file.Close()
+	}
+}
+
+// Opens the partial download for writing. An offset of zero starts the file over.
+func (c *Cache) openPartial(filename string, offset int64) (*os.File, error) {
+	flags := os.O_RDWR | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(c.partialPath(filename), flags, 0664)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(offset, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Returns the first byte position of a Content-Range header (e.g. "bytes 100-199/200").
+func contentRangeStart(header string) (int64, error) {
+	var start, end int64
+	var total string
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		return 0, err
+	}
+	return start, nil
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheResumePartialDownload(t *testing.T) {
+	t.Parallel()
+
+	original := strings.Repeat("a", 32<<10) + strings.Repeat("b", 32<<10)
+	changed := strings.Repeat("c", 64<<10)
+
+	tests := []struct {
+		name          string
+		secondETag    string
+		secondContent string
+		expectRange   bool
+		expected      string
+	}{
+		{
+			name:          "unchanged file is resumed",
+			secondETag:    `"v1"`,
+			secondContent: original,
+			expectRange:   true,
+			expected:      original,
+		},
+		{
+			name:          "changed file starts over",
+			secondETag:    `"v2"`,
+			secondContent: changed,
+			expectRange:   false,
+			expected:      changed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var attempt atomic.Int32
+			var resumedFrom atomic.Int64
+
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if attempt.Add(1) == 1 {
+					// The first transfer breaks halfway through.
+					w.Header().Set("ETag", `"v1"`)
+					w.Header().Set("Content-Length", strconv.Itoa(len(original)))
+					w.Write([]byte(original[:len(original)/2]))
+					return
+				}
+
+				if rng := r.Header.Get("Range"); rng != "" && r.Header.Get("If-Range") == tt.secondETag {
+					start, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"), 10, 64)
+					resumedFrom.Store(start)
+				}
+
+				w.Header().Set("ETag", tt.secondETag)
+				http.ServeContent(w, r, testArtifact, time.Time{}, strings.NewReader(tt.secondContent))
+			}))
+			defer upstream.Close()
+
+			cache := NewCache(CacheOptions{
+				UpstreamHost: upstream.URL,
+				CacheDir:     t.TempDir(),
+				Unlisted:     UnlistedAccept,
+			})
+
+			f, _ := cache.startFill(slog.Default(), testArtifact, "0.14.1", priorityInteractive)
+			if err := f.wait(context.Background()); err == nil {
+				t.Fatal("expected the first download to fail")
+			}
+
+			info, err := os.Stat(cache.partialPath(testArtifact))
+			if err != nil {
+				t.Fatalf("partial download was not kept: %v", err)
+			}
+			if info.Size() != int64(len(original)/2) {
+				t.Fatalf("got partial size %d, want %d", info.Size(), len(original)/2)
+			}
+
+			f, _ = cache.startFill(slog.Default(), testArtifact, "0.14.1", priorityInteractive)
+			if err := f.wait(context.Background()); err != nil {
+				t.Fatalf("second download failed: %v", err)
+			}
+
+			if got := resumedFrom.Load(); (got == int64(len(original)/2)) != tt.expectRange {
+				t.Errorf("got resumed from %d, want resumed %v", got, tt.expectRange)
+			}
+
+			data, err := os.ReadFile(f.finalPath)
+			if err != nil {
+				t.Fatalf("file was not cached: %v", err)
+			}
+			if string(data) != tt.expected {
+				t.Errorf("cached file has unexpected content")
+			}
+
+			if fileExists(cache.partialPath(testArtifact)) || fileExists(cache.partialPath(testArtifact)+".json") {
+				t.Errorf("partial download was not cleaned up")
+			}
+		})
+	}
+}
+
+func TestCachePartialLock(t *testing.T) {
+	t.Parallel()
+
+	const content = "zig tarball"
+
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(upstream.Close)
+
+	cacheDir := t.TempDir()
+	cache := NewCache(CacheOptions{
+		UpstreamHost: upstream.URL,
+		CacheDir:     cacheDir,
+		Unlisted:     UnlistedAccept,
+	})
+
+	// Like a -prefetch run, another process fills the same cache directory.
+	other := NewCache(CacheOptions{CacheDir: cacheDir})
+	lock, err := other.lockPartial(context.Background(), slog.Default(), testArtifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fill waits for the lock as long as its context allows.
+	finalPath := filepath.Join(cache.destinationDir("0.14.1"), testArtifact)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := cache.fetchAndCacheFile(ctx, slog.Default(), newFill(testArtifact, "0.14.1", finalPath)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v while another process holds the lock, want %v", err, context.DeadlineExceeded)
+	}
+
+	status := make(chan int, 1)
+	go func() {
+		status <- fetchFromMirror(t, cache, "/download/0.14.1/"+testArtifact, content)
+	}()
+
+	time.Sleep(2 * partialLockPoll)
+	if fileExists(cache.partialPath(testArtifact)) || requests.Load() != 0 {
+		t.Errorf("got a partial download or %v upstream requests while another process holds the lock, want neither", requests.Load())
+	}
+
+	// The other process caches the file and releases the lock, the waiting client gets the cached file.
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(finalPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unlockFile(lock)
+
+	if got := <-status; got != http.StatusOK {
+		t.Errorf("got status %v for a file cached by another process, want %v", got, http.StatusOK)
+	}
+	if requests.Load() != 0 {
+		t.Errorf("got %v upstream requests for a file cached by another process, want none", requests.Load())
+	}
+
+	// The lock is released with its file.
+	if fileExists(cache.partialPath(testArtifact) + ".lock") {
+		t.Error("got a lock file left behind")
+	}
+}
+
+func TestContentRangeStart(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in          string
+		expected    int64
+		expectError bool
+	}{
+		{"bytes 100-199/200", 100, false},
+		{"bytes 0-0/*", 0, false},
+		{"", 0, true},
+		{"bytes */200", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := contentRangeStart(tt.in)
+			if (err != nil) != tt.expectError || got != tt.expected {
+				t.Errorf("got (%d, %v), want (%d, error %v)", got, err, tt.expected, tt.expectError)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/metrics"
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// Interval after which idle clients are forgotten.
+const clientSweepInterval = time.Minute
+
+// Retry-After of clients with too many concurrent downloads.
+const downloadsRetryAfter = 5 * time.Second
+
+// Maximum number of bytes written at once by a throttled response.
+const maxThrottledChunk = 32 << 10
+
+// RateLimitOptions holds the per-client limits, a zero value disables a limit.
+type RateLimitOptions struct {
+	// RequestsPerSecond is the sustained request rate of a client, Burst is the number of requests it can make at once.
+	RequestsPerSecond float64
+	Burst             int
+	// MaxDownloads is the number of artifacts a client can download at the same time.
+	MaxDownloads int
+	// BytesPerSecond limits the transfer rate of every response.
+	BytesPerSecond int64
+	// Metrics of the mirror, defaults to a new set of metrics.
+	Metrics *metrics.Metrics
+}
+
+// RateLimiter limits the requests and concurrent downloads of every client IP, as returned by GetRemoteIP.
+// Clients over a limit get a 429 Too Many Requests response with a Retry-After header.
+type RateLimiter struct {
+	opts RateLimitOptions
+	now  func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimits
+	lastSweep time.Time
+}
+
+// Token bucket and in-flight downloads of a single client.
+type clientLimits struct {
+	tokens    float64
+	updated   time.Time
+	downloads int
+}
+
+// Creates a rate limiter, it is used by passing it to the Middleware.
+func NewRateLimiter(opts RateLimitOptions) *RateLimiter {
+	if opts.Burst < 1 {
+		opts.Burst = 1
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.New()
+	}
+
+	return &RateLimiter{
+		opts:    opts,
+		now:     time.Now,
+		clients: make(map[string]*clientLimits),
+	}
+}
+
+func (l *RateLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := GetRemoteIP(*r)
+
+		if ok, retryAfter := l.allow(ip); !ok {
+			l.opts.Metrics.RateLimited.Inc("requests")
+			tooManyRequests(w, retryAfter, "Too many requests")
+			return
+		}
+
+		// Only artifacts are long-running downloads, the index page and index.json are not limited.
+		if l.opts.MaxDownloads > 0 && zig.IsZigArtifact(path.Base(r.URL.Path)) {
+			if !l.acquireDownload(ip) {
+				l.opts.Metrics.RateLimited.Inc("downloads")
+				tooManyRequests(w, downloadsRetryAfter, "Too many concurrent downloads")
+				return
+			}
+			defer l.releaseDownload(ip)
+		}
+
+		if l.opts.BytesPerSecond > 0 {
+			w = &throttledWriter{ResponseWriter: w, ctx: r.Context(), pacer: newPacer(l.opts.BytesPerSecond)}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Takes a token from the bucket of the client, or returns how long it has to wait for the next one.
+func (l *RateLimiter) allow(ip string) (bool, time.Duration) {
+	if l.opts.RequestsPerSecond <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+
+	client := l.clientLocked(ip, now)
+	burst := float64(l.opts.Burst)
+	client.tokens = min(burst, client.tokens+now.Sub(client.updated).Seconds()*l.opts.RequestsPerSecond)
+	client.updated = now
+
+	if client.tokens < 1 {
+		return false, time.Duration((1 - client.tokens) / l.opts.RequestsPerSecond * float64(time.Second))
+	}
+	client.tokens--
+	return true, 0
+}
+
+func (l *RateLimiter) acquireDownload(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client := l.clientLocked(ip, l.now())
+	if client.downloads >= l.opts.MaxDownloads {
+		return false
+	}
+	client.downloads++
+	return true
+}
+
+func (l *RateLimiter) releaseDownload(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if client, ok := l.clients[ip]; ok {
+		client.downloads--
+		// The sweep only runs with a request rate limit, so idle clients are forgotten right away.
+		if l.idleLocked(client, l.now()) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+func (l *RateLimiter) clientLocked(ip string, now time.Time) *clientLimits {
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimits{tokens: float64(l.opts.Burst), updated: now}
+		l.clients[ip] = client
+	}
+	return client
+}
+
+// Forgets the clients without downloads whose bucket is full again, so that the map doesn't grow forever.
+func (l *RateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < clientSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for ip, client := range l.clients {
+		if l.idleLocked(client, now) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// Reports whether a client has no downloads and a full bucket, so that forgetting it changes nothing.
+func (l *RateLimiter) idleLocked(client *clientLimits, now time.Time) bool {
+	if client.downloads > 0 {
+		return false
+	}
+	if l.opts.RequestsPerSecond <= 0 {
+		return true
+	}
+	return client.tokens+now.Sub(client.updated).Seconds()*l.opts.RequestsPerSecond >= float64(l.opts.Burst)
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := max(1, int(math.Ceil(retryAfter.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+// A response writer that limits the transfer rate of the response body.
+// It doesn't implement io.ReaderFrom, so that all data goes through Write.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx   context.Context
+	pacer pacer
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	chunkSize := int(min(w.pacer.rate, maxThrottledChunk))
+
+	for len(p) > 0 {
+		chunk := p[:min(len(p), chunkSize)]
+
+		if err := w.pacer.wait(w.ctx); err != nil {
+			return written, err
+		}
+
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		w.pacer.add(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+
+		// Send every chunk right away instead of buffering it.
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+	return written, nil
+}
+
+// Unwrap allows http.ResponseController to reach the underlying response writer.
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
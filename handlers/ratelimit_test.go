@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/metrics"
+)
+
+func TestRateLimiterRequests(t *testing.T) {
+	t.Parallel()
+
+	m := metrics.New()
+	limiter := NewRateLimiter(RateLimitOptions{RequestsPerSecond: 1, Burst: 2, Metrics: m})
+
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), MiddlewareOptions{RateLimiter: limiter})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		advance    time.Duration
+		want       int
+		retryAfter string
+	}{
+		{"First request", "1.2.3.4:1000", 0, http.StatusOK, ""},
+		{"Within the burst", "1.2.3.4:1000", 0, http.StatusOK, ""},
+		{"Over the limit", "1.2.3.4:1000", 0, http.StatusTooManyRequests, "1"},
+		{"Another client", "5.6.7.8:1000", 0, http.StatusOK, ""},
+		{"Token refilled", "1.2.3.4:1000", time.Second, http.StatusOK, ""},
+		{"Over the limit again", "1.2.3.4:1000", 100 * time.Millisecond, http.StatusTooManyRequests, "1"},
+	}
+
+	// The requests depend on each other and run in order.
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.want {
+			t.Errorf("%s: got status %v, want %v", tt.name, rr.Code, tt.want)
+		}
+		if got := rr.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("%s: got Retry-After %q, want %q", tt.name, got, tt.retryAfter)
+		}
+	}
+
+	if got := m.RateLimited.Value("requests"); got != 2 {
+		t.Errorf("got %v rate limited requests, want %v", got, 2)
+	}
+}
+
+func TestRateLimiterDownloads(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewRateLimiter(RateLimitOptions{MaxDownloads: 1})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".tar.xz") {
+			started <- struct{}{}
+			<-release
+		}
+	}), MiddlewareOptions{RateLimiter: limiter})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		if rr := get("/download/0.14.1/" + testArtifact); rr.Code != http.StatusOK {
+			t.Errorf("got status %v, want %v", rr.Code, http.StatusOK)
+		}
+	})
+	<-started
+
+	if rr := get("/download/0.14.1/" + testArtifact); rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "5" {
+		t.Errorf("got status %v and Retry-After %q, want %v and %q", rr.Code, rr.Header().Get("Retry-After"), http.StatusTooManyRequests, "5")
+	}
+	if rr := get("/download/index.json"); rr.Code != http.StatusOK {
+		t.Errorf("got status %v for a request that is not a download, want %v", rr.Code, http.StatusOK)
+	}
+
+	release <- struct{}{}
+	wg.Wait()
+
+	wg.Go(func() {
+		if rr := get("/download/0.14.1/" + testArtifact); rr.Code != http.StatusOK {
+			t.Errorf("got status %v after the first download finished, want %v", rr.Code, http.StatusOK)
+		}
+	})
+	<-started
+	release <- struct{}{}
+	wg.Wait()
+
+	// Without a request rate limit there is no sweep, clients are forgotten after their last download.
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if got := len(limiter.clients); got != 0 {
+		t.Errorf("got %v remembered clients, want %v", got, 0)
+	}
+}
+
+func TestRateLimiterForgetsClients(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		opts     RateLimitOptions
+		advance  time.Duration // between the end of the download and the next request of another client
+		expected int           // remembered clients afterwards
+	}{
+		{"downloads only", RateLimitOptions{MaxDownloads: 1}, 0, 0},
+		{"bucket still refilling", RateLimitOptions{MaxDownloads: 1, RequestsPerSecond: 1, Burst: 2}, 0, 2},
+		{"bucket refilled", RateLimitOptions{MaxDownloads: 1, RequestsPerSecond: 1, Burst: 2}, 2 * clientSweepInterval, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := NewRateLimiter(tt.opts)
+			now := time.Now()
+			limiter.now = func() time.Time { return now }
+
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), MiddlewareOptions{RateLimiter: limiter})
+			get := func(remoteAddr string) {
+				req := httptest.NewRequest("GET", "/download/0.14.1/"+testArtifact, nil)
+				req.RemoteAddr = remoteAddr
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			get("1.2.3.4:1000")
+			now = now.Add(tt.advance)
+			get("5.6.7.8:1000")
+
+			limiter.mu.Lock()
+			defer limiter.mu.Unlock()
+			if got := len(limiter.clients); got != tt.expected {
+				t.Errorf("got %v remembered clients, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestThrottledWriter(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewRateLimiter(RateLimitOptions{BytesPerSecond: 4000})
+	body := strings.Repeat("a", 6000)
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}), MiddlewareOptions{RateLimiter: limiter})
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if got := rr.Body.String(); got != body {
+		t.Errorf("got %v bytes, want %v", len(got), len(body))
+	}
+	// 4000 bytes are sent right away, the remaining 2000 after a second.
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("got a response after %v, want at least a second", elapsed)
+	}
+}
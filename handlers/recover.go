@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// Kinds of files found by the startup recovery.
+const (
+	RecoveryStaleTemp = "stale-temp" // a temporary file or an orphaned partial download left behind by a crash
+	RecoveryMisplaced = "misplaced"  // an artifact or signature outside of the directory of its version
+	RecoveryUnknown   = "unknown"    // a file that doesn't belong in the cache layout
+)
+
+// RecoveryOptions holds the settings of the startup recovery.
+type RecoveryOptions struct {
+	// GracePeriod protects temporary files that may still be in use, only older ones are removed.
+	GracePeriod time.Duration
+	// Fix moves misplaced files into place, otherwise they are only reported.
+	Fix bool
+}
+
+// RecoveryFinding is a file found by the startup recovery.
+type RecoveryFinding struct {
+	// Path and Destination are relative to the cache directory, Destination is where a misplaced file belongs.
+	Path        string
+	Kind        string
+	Reason      string
+	Destination string
+	// Action is what happened to the file: "removed", "moved", "reported" or "failed".
+	Action string
+}
+
+// RecoveryReport summarizes the startup recovery.
+type RecoveryReport struct {
+	// Checked counts the files that are in place.
+	Checked  int
+	Findings []RecoveryFinding
+}
+
+// Recover checks the cache directory for what a crash or manual changes leave behind.
+// Stale temporary files and partial downloads that can't be resumed are removed,
+// misplaced artifacts and signatures are moved into place if opts.Fix is set,
+// and files that don't belong in the cache layout are only reported.
+// Only download/, builds/ and the partial downloads and temporary files of this program in the cache directory itself are checked,
+// so that a cache directory shared with other files is safe.
+// It must run before the cache is used, files that are moved or removed are not coordinated with fills.
+func (c *Cache) Recover(ctx context.Context, opts RecoveryOptions) (RecoveryReport, error) {
+	var report RecoveryReport
+
+	check := func(name string, finding RecoveryFinding, ok bool) {
+		if !ok {
+			report.Checked++
+			return
+		}
+		finding.Action = c.applyRecovery(name, finding, opts.Fix)
+		report.Findings = append(report.Findings, finding)
+	}
+
+	entries, err := os.ReadDir(c.cacheDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return report, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name := filepath.Join(c.cacheDir, entry.Name())
+		if finding, ok, owned := c.checkRootFile(name, entry.Name(), opts.GracePeriod); owned {
+			check(name, finding, ok)
+		}
+	}
+
+	for _, dir := range []string{"download", "builds"} {
+		err := filepath.WalkDir(filepath.Join(c.cacheDir, dir), func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Nothing is cached yet, or the file was removed since the walk listed it.
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			finding, ok := c.checkLayout(name, c.relativePath(name), opts.GracePeriod)
+			check(name, finding, ok)
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// Reports whether a file name is one of the temporary files this program creates next to the files it writes:
+// <zig artifact>.*.tmp (including signatures and the validators of partial downloads), index.json.*.tmp,
+// imported-builds.txt.*.tmp and .readyz-*.tmp.
+func ownTempFile(base string) bool {
+	if strings.HasPrefix(base, ".readyz-") && strings.HasSuffix(base, ".tmp") {
+		return true
+	}
+
+	// os.CreateTemp replaces the * with a random number.
+	name, ok := strings.CutSuffix(base, ".tmp")
+	if !ok {
+		return false
+	}
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 || i == len(name)-1 || strings.Trim(name[i+1:], "0123456789") != "" {
+		return false
+	}
+	name = strings.TrimSuffix(name[:i], partialMetaSuffix)
+	return name == "index.json" || name == importedBuildsFile || zig.IsZigArtifact(name)
+}
+
+// Returns a finding for a stale file, or false if it is younger than the grace period and may still be in use.
+func staleFinding(name, rel, reason string, gracePeriod time.Duration) (RecoveryFinding, bool) {
+	finding := RecoveryFinding{Path: rel}
+	info, err := os.Stat(name)
+	if err != nil || time.Since(info.ModTime()) < gracePeriod {
+		return finding, false
+	}
+	finding.Kind, finding.Reason = RecoveryStaleTemp, reason
+	return finding, true
+}
+
+// Checks a file in the cache directory itself, where only partial downloads and temporary files are written.
+// owned is false for the files that don't belong to this program, they are left alone.
+func (c *Cache) checkRootFile(name, base string, gracePeriod time.Duration) (finding RecoveryFinding, ok, owned bool) {
+	// Partial downloads are kept with their validators, one without the other can't be resumed.
+	if filename, found := strings.CutSuffix(base, partialMetaSuffix); found && zig.IsZigArtifact(filename) {
+		if !fileExists(c.partialPath(filename)) {
+			finding, ok = staleFinding(name, base, "validators of a partial download without its data", gracePeriod)
+		}
+		return finding, ok, true
+	}
+	if filename, found := strings.CutSuffix(base, partialSuffix); found && zig.IsZigArtifact(filename) {
+		if !fileExists(c.partialPath(filename) + ".json") {
+			finding, ok = staleFinding(name, base, "partial download without its validators", gracePeriod)
+		}
+		return finding, ok, true
+	}
+	if ownTempFile(base) {
+		finding, ok = staleFinding(name, base, "temporary file", gracePeriod)
+		return finding, ok, true
+	}
+	return finding, false, false
+}
+
+// Returns what is wrong with a file in download/ or builds/, or false if it is in place.
+func (c *Cache) checkLayout(name, rel string, gracePeriod time.Duration) (RecoveryFinding, bool) {
+	finding := RecoveryFinding{Path: rel}
+	base := path.Base(rel)
+
+	switch {
+	case ownTempFile(base):
+		return staleFinding(name, rel, "temporary file", gracePeriod)
+	case rel == "download/index.json":
+		return finding, false
+	}
+
+	submatches := zig.ArtifactSubmatches(base)
+	if submatches == nil {
+		finding.Kind, finding.Reason = RecoveryUnknown, "not part of the cache layout"
+		return finding, true
+	}
+
+	expected := c.relativePath(filepath.Join(c.destinationDir(submatches[1]), base))
+	if rel != expected {
+		finding.Kind, finding.Reason, finding.Destination = RecoveryMisplaced, "not in the directory of version "+submatches[1], expected
+		return finding, true
+	}
+
+	if artifact, ok := strings.CutSuffix(name, ".minisig"); ok && !fileExists(artifact) {
+		finding.Kind, finding.Reason = RecoveryUnknown, "signature without its artifact"
+		return finding, true
+	}
+
+	return finding, false
+}
+
+// Removes a stale file or moves a misplaced one if fix is set, and returns what was done.
+func (c *Cache) applyRecovery(name string, finding RecoveryFinding, fix bool) string {
+	logger := slog.With("path", finding.Path, "kind", finding.Kind, "reason", finding.Reason)
+
+	switch {
+	case finding.Kind == RecoveryStaleTemp:
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("failed to remove a stale temporary file", "error", err)
+			return "failed"
+		}
+		logger.Info("removed a stale temporary file")
+		return "removed"
+
+	case finding.Kind == RecoveryMisplaced && fix:
+		destination := filepath.Join(c.cacheDir, filepath.FromSlash(finding.Destination))
+		if fileExists(destination) {
+			logger.Warn("found a misplaced file, it is already cached in the right place", "destination", finding.Destination)
+			return "reported"
+		}
+
+		err := os.MkdirAll(filepath.Dir(destination), 0755)
+		if err == nil {
+			err = c.evictor.track(func() error { return os.Rename(name, destination) }, name, destination)
+		}
+		if err != nil {
+			logger.Error("failed to move a misplaced file", "destination", finding.Destination, "error", err)
+			return "failed"
+		}
+		logger.Info("moved a misplaced file into place", "destination", finding.Destination)
+		return "moved"
+
+	case finding.Kind == RecoveryMisplaced:
+		logger.Warn("found a misplaced file", "destination", finding.Destination)
+	default:
+		logger.Warn("found a file that doesn't belong in the cache")
+	}
+	return "reported"
+}
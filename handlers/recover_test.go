@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheRecover(t *testing.T) {
+	t.Parallel()
+
+	const devBuild = "zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz"
+	stable := "download/0.14.1/" + testArtifact
+
+	tests := []struct {
+		name           string
+		files          []string // the first one is checked
+		old            bool     // older than the grace period
+		fix            bool
+		expectedKind   string // empty means in place
+		expectedAction string
+		expectedPath   string // where the file is afterwards, empty means removed
+	}{
+		{"cached artifact", []string{stable}, true, true, "", "", stable},
+		{"stored index.json", []string{"download/index.json"}, true, true, "", "", "download/index.json"},
+		{"stale temporary file", []string{"download/0.14.1/" + testArtifact + ".minisig.123.tmp"}, true, false, RecoveryStaleTemp, "removed", ""},
+		{"recent temporary file", []string{"download/index.json.123.tmp"}, false, false, "", "", "download/index.json.123.tmp"},
+		{"resumable partial download", []string{testArtifact + ".partial", testArtifact + ".partial.json"}, true, false, "", "", testArtifact + ".partial"},
+		{"partial download without validators", []string{testArtifact + ".partial"}, true, false, RecoveryStaleTemp, "removed", ""},
+		{"validators without a partial download", []string{testArtifact + ".partial.json"}, true, false, RecoveryStaleTemp, "removed", ""},
+		{"dev build under download", []string{"download/0.17.0-dev.305+bdfbf432d/" + devBuild}, true, true, RecoveryMisplaced, "moved", "builds/" + devBuild},
+		{"version mismatch", []string{"download/0.14.0/" + testArtifact}, true, true, RecoveryMisplaced, "moved", stable},
+		{"misplaced signature", []string{"download/0.14.0/" + testArtifact + ".minisig", stable}, true, true, RecoveryMisplaced, "moved", stable + ".minisig"},
+		{"misplaced without fix", []string{"download/0.14.0/" + testArtifact}, true, false, RecoveryMisplaced, "reported", "download/0.14.0/" + testArtifact},
+		{"misplaced and already cached", []string{"download/0.14.0/" + testArtifact, stable}, true, true, RecoveryMisplaced, "reported", "download/0.14.0/" + testArtifact},
+		{"signature without its artifact", []string{stable + ".minisig"}, true, true, RecoveryUnknown, "reported", stable + ".minisig"},
+		{"unknown file", []string{"download/0.14.1/README.txt"}, true, true, RecoveryUnknown, "reported", "download/0.14.1/README.txt"},
+		{"stale validators temporary file", []string{testArtifact + ".partial.json.42.tmp"}, true, false, RecoveryStaleTemp, "removed", ""},
+		{"stale readiness probe file", []string{".readyz-42.tmp"}, true, false, RecoveryStaleTemp, "removed", ""},
+		{"stale imported builds temporary file", []string{importedBuildsFile + ".42.tmp"}, true, false, RecoveryStaleTemp, "removed", ""},
+		{"imported builds", []string{importedBuildsFile}, true, true, "", "", importedBuildsFile},
+		{"foreign temporary file", []string{"notes.tmp"}, true, true, "", "", "notes.tmp"},
+		{"foreign file in the cache directory", []string{"SHA256SUMS"}, true, true, "", "", "SHA256SUMS"},
+		{"foreign temporary file in a subdirectory", []string{"acme/notes.tmp"}, true, true, "", "", "acme/notes.tmp"},
+		{"foreign temporary file under download", []string{"download/notes.tmp"}, true, true, RecoveryUnknown, "reported", "download/notes.tmp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir := t.TempDir()
+			// Quarantined files are left alone.
+			tt.files = append(tt.files, "quarantine/download/0.14.0/"+testArtifact)
+
+			for _, name := range tt.files {
+				path := filepath.Join(cacheDir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte("zig"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if tt.old {
+					modTime := time.Now().Add(-2 * time.Hour)
+					if err := os.Chtimes(path, modTime, modTime); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			cache := NewCache(CacheOptions{CacheDir: cacheDir})
+			report, err := cache.Recover(context.Background(), RecoveryOptions{GracePeriod: time.Hour, Fix: tt.fix})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.expectedKind == "" {
+				if len(report.Findings) != 0 {
+					t.Errorf("got findings %+v, want none", report.Findings)
+				}
+			} else {
+				if len(report.Findings) != 1 {
+					t.Fatalf("got findings %+v, want one", report.Findings)
+				}
+				finding := report.Findings[0]
+				if finding.Path != tt.files[0] || finding.Kind != tt.expectedKind || finding.Action != tt.expectedAction {
+					t.Errorf("got %+v, want %v %v of %v", finding, tt.expectedKind, tt.expectedAction, tt.files[0])
+				}
+			}
+
+			if tt.expectedPath != "" && !fileExists(filepath.Join(cacheDir, filepath.FromSlash(tt.expectedPath))) {
+				t.Errorf("got no file at %v", tt.expectedPath)
+			}
+			if tt.expectedPath != tt.files[0] && fileExists(filepath.Join(cacheDir, filepath.FromSlash(tt.files[0]))) {
+				t.Errorf("got %v left in place", tt.files[0])
+			}
+		})
+	}
+}
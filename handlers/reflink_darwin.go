@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Creates destination as a copy-on-write clone of source, supported by APFS.
+func reflink(source, destination string) error {
+	if err := unix.Clonefile(source, destination, unix.CLONE_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "reflink", Path: destination, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Creates destination as a copy-on-write clone of source (FICLONE), supported by e.g. Btrfs and XFS.
+func reflink(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		return &os.PathError{Op: "reflink", Path: destination, Err: err}
+	}
+	return out.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin)
+
+package handlers
+
+import "errors"
+
+// Reflinks are not supported on this platform.
+func reflink(source, destination string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// ReleasesHandler returns the http.HandlerFunc serving the cached upstream index.json.
+// If publicURL is not empty, the tarball URLs in the served index.json point at this mirror.
+func ReleasesHandler(index *zig.Index, publicURL string) http.HandlerFunc {
+	// The rewritten index.json is reused until a new one is fetched.
+	var mu sync.Mutex
+	var rewritten []byte
+	var rewrittenAt time.Time
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.With(
+			"remote_ip", GetRemoteIP(*r),
+			"path", r.URL.Path,
+			"source", GetSource(*r),
+		)
+
+		raw, fetchedAt, err := index.Raw(r.Context())
+		if err != nil {
+			logger.Error("failed to fetch the upstream index.json", "error", err)
+			http.Error(w, "Upstream index.json is unavailable", http.StatusBadGateway)
+			return
+		}
+
+		body := raw
+		if publicURL != "" {
+			mu.Lock()
+			if !rewrittenAt.Equal(fetchedAt) {
+				if rewritten, err = zig.RewriteTarballs(raw, publicURL); err != nil {
+					rewrittenAt = time.Time{}
+				} else {
+					rewrittenAt = fetchedAt
+				}
+			}
+			body = rewritten
+			mu.Unlock()
+
+			if err != nil {
+				logger.Error("failed to rewrite the tarball URLs of index.json", "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeContent(w, r, "index.json", fetchedAt, bytes.NewReader(body))
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+func TestReleasesHandler(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"0.14.1": {
+				"version": "0.14.1",
+				"x86_64-linux": {
+					"tarball": "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz",
+					"shasum": "abc",
+					"size": "1"
+				}
+			}
+		}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(broken.Close)
+
+	tests := []struct {
+		name           string
+		upstream       string
+		publicURL      string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Upstream URLs are kept",
+			upstream:       upstream.URL,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz",
+		},
+		{
+			name:           "Tarball URLs point at the mirror",
+			upstream:       upstream.URL,
+			publicURL:      "https://zig.example.com",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "https://zig.example.com/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz",
+		},
+		{
+			name:           "Unavailable upstream",
+			upstream:       broken.URL,
+			expectedStatus: http.StatusBadGateway,
+			expectedBody:   "Upstream index.json is unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			index := zig.NewIndex([]string{tt.upstream}, time.Minute, "")
+			handler := ReleasesHandler(index, tt.publicURL)
+
+			req := httptest.NewRequest("GET", "/download/index.json", nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("got status %v, want %v", status, tt.expectedStatus)
+			}
+
+			if body := rr.Body.String(); !strings.Contains(body, tt.expectedBody) {
+				t.Errorf("got body %q, want it to contain %q", body, tt.expectedBody)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				if got := rr.Header().Get("Content-Type"); got != "application/json" {
+					t.Errorf("got Content-Type %v, want application/json", got)
+				}
+			}
+		})
+	}
+}
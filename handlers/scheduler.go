@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+)
+
+// fillPriority orders the fills waiting for a free slot, lower values go first.
+type fillPriority int
+
+const (
+	// Fills a client is waiting for.
+	priorityInteractive fillPriority = iota
+	// Fills of prefetch and sync.
+	priorityBackground
+)
+
+func (p fillPriority) String() string {
+	if p == priorityInteractive {
+		return "interactive"
+	}
+	return "background"
+}
+
+// fillScheduler limits the number of concurrent upstream fills.
+// Fills over the limit wait in a queue, interactive fills before background fills, otherwise first come, first served.
+type fillScheduler struct {
+	limit int // zero means unlimited
+
+	mu     sync.Mutex
+	active int
+	queue  []*fillTicket
+	seq    uint64
+	closed error // returned to the fills that are queued or try to start after close
+}
+
+// A fill's place in the queue of the scheduler.
+type fillTicket struct {
+	priority fillPriority
+	seq      uint64
+	granted  bool
+	err      error         // why the fill may not start, set before ready is closed
+	ready    chan struct{} // closed once the fill may start or was cancelled
+}
+
+func newFillScheduler(limit int) *fillScheduler {
+	return &fillScheduler{limit: limit}
+}
+
+func (s *fillScheduler) newTicket(priority fillPriority) *fillTicket {
+	return &fillTicket{priority: priority, ready: make(chan struct{})}
+}
+
+// Waits until the fill may start, or ctx is cancelled.
+// If the fill has to wait, queued is called with the number of queued fills, including this one.
+func (s *fillScheduler) acquire(ctx context.Context, t *fillTicket, queued func(depth int)) error {
+	s.mu.Lock()
+	if s.closed != nil {
+		s.mu.Unlock()
+		return s.closed
+	}
+	if s.limit <= 0 || (s.active < s.limit && len(s.queue) == 0) {
+		s.active++
+		t.granted = true
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.seq++
+	t.seq = s.seq
+	s.queue = append(s.queue, t)
+	depth := len(s.queue)
+	s.mu.Unlock()
+
+	if queued != nil {
+		queued(depth)
+	}
+
+	select {
+	case <-t.ready:
+		return t.err
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if t.err != nil {
+			return t.err
+		}
+		if t.granted {
+			// The slot was granted in the meantime, pass it on.
+			s.active--
+			s.grantLocked()
+		} else {
+			s.queue = slices.DeleteFunc(s.queue, func(queuedTicket *fillTicket) bool { return queuedTicket == t })
+		}
+		return ctx.Err()
+	}
+}
+
+// Frees the slot of a finished fill.
+func (s *fillScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	s.grantLocked()
+}
+
+// Raises the priority of a queued fill, e.g. when a client joins a prefetch.
+func (s *fillScheduler) promote(t *fillTicket, priority fillPriority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.priority = min(t.priority, priority)
+}
+
+func (s *fillScheduler) grantLocked() {
+	for s.active < s.limit && len(s.queue) > 0 {
+		next := slices.MinFunc(s.queue, func(a, b *fillTicket) int {
+			return cmp.Or(cmp.Compare(a.priority, b.priority), cmp.Compare(a.seq, b.seq))
+		})
+		s.queue = slices.DeleteFunc(s.queue, func(t *fillTicket) bool { return t == next })
+
+		s.active++
+		next.granted = true
+		close(next.ready)
+	}
+}
+
+// Cancels the queued fills with err and refuses new ones, the running fills are not affected.
+// It returns the tickets of the cancelled fills.
+func (s *fillScheduler) close(err error) []*fillTicket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = err
+	cancelled := s.queue
+	s.queue = nil
+	for _, t := range cancelled {
+		t.err = err
+		close(t.ready)
+	}
+	return cancelled
+}
+
+// Returns the number of running and queued fills.
+func (s *fillScheduler) stats() (active, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active, len(s.queue)
+}
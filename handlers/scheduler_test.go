@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Acquires a slot in the background and returns a channel that receives the result.
+func acquireAsync(s *fillScheduler, ctx context.Context, t *fillTicket) <-chan error {
+	queued := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- s.acquire(ctx, t, func(int) { close(queued) })
+	}()
+
+	// Wait until the ticket is in the queue, so that the order of the tickets is deterministic.
+	select {
+	case <-queued:
+	case <-result:
+		panic("the ticket was granted without waiting")
+	}
+	return result
+}
+
+func expectGranted(t *testing.T, name string, result <-chan error) {
+	t.Helper()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("%s: got error %v, want nil", name, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: the fill was never allowed to start", name)
+	}
+}
+
+func expectWaiting(t *testing.T, name string, result <-chan error) {
+	t.Helper()
+	select {
+	case err := <-result:
+		t.Fatalf("%s: got result %v, want the fill to wait", name, err)
+	default:
+	}
+}
+
+func TestFillSchedulerPriority(t *testing.T) {
+	t.Parallel()
+
+	s := newFillScheduler(1)
+	ctx := context.Background()
+
+	if err := s.acquire(ctx, s.newTicket(priorityBackground), nil); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	background := acquireAsync(s, ctx, s.newTicket(priorityBackground))
+	promotedTicket := s.newTicket(priorityBackground)
+	promoted := acquireAsync(s, ctx, promotedTicket)
+	interactive := acquireAsync(s, ctx, s.newTicket(priorityInteractive))
+
+	if active, queued := s.stats(); active != 1 || queued != 3 {
+		t.Errorf("got %v active and %v queued fills, want %v and %v", active, queued, 1, 3)
+	}
+
+	s.promote(promotedTicket, priorityInteractive)
+
+	// The interactive fills go first in the order they were queued, the background fill last.
+	s.release()
+	expectGranted(t, "promoted", promoted)
+	expectWaiting(t, "interactive", interactive)
+	expectWaiting(t, "background", background)
+
+	s.release()
+	expectGranted(t, "interactive", interactive)
+	expectWaiting(t, "background", background)
+
+	s.release()
+	expectGranted(t, "background", background)
+
+	s.release()
+	if active, queued := s.stats(); active != 0 || queued != 0 {
+		t.Errorf("got %v active and %v queued fills, want %v and %v", active, queued, 0, 0)
+	}
+}
+
+func TestFillSchedulerCancel(t *testing.T) {
+	t.Parallel()
+
+	s := newFillScheduler(1)
+
+	if err := s.acquire(context.Background(), s.newTicket(priorityInteractive), nil); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := acquireAsync(s, ctx, s.newTicket(priorityInteractive))
+	waiting := acquireAsync(s, context.Background(), s.newTicket(priorityBackground))
+
+	cancel()
+	if err := <-cancelled; err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if _, queued := s.stats(); queued != 1 {
+		t.Errorf("got %v queued fills, want %v", queued, 1)
+	}
+
+	s.release()
+	expectGranted(t, "waiting", waiting)
+}
+
+func TestFillSchedulerUnlimited(t *testing.T) {
+	t.Parallel()
+
+	s := newFillScheduler(0)
+
+	for range 100 {
+		if err := s.acquire(context.Background(), s.newTicket(priorityBackground), nil); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+	}
+
+	if active, queued := s.stats(); active != 100 || queued != 0 {
+		t.Errorf("got %v active and %v queued fills, want %v and %v", active, queued, 100, 0)
+	}
+}
+
+func TestFillSchedulerClose(t *testing.T) {
+	t.Parallel()
+
+	s := newFillScheduler(1)
+	errClosed := errors.New("closed")
+
+	if err := s.acquire(context.Background(), s.newTicket(priorityInteractive), nil); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+	queuedTicket := s.newTicket(priorityBackground)
+	queued := acquireAsync(s, context.Background(), queuedTicket)
+
+	if cancelled := s.close(errClosed); len(cancelled) != 1 || cancelled[0] != queuedTicket {
+		t.Errorf("got cancelled tickets %v, want the queued one", cancelled)
+	}
+	if err := <-queued; err != errClosed {
+		t.Errorf("got error %v for the queued fill, want %v", err, errClosed)
+	}
+	if err := s.acquire(context.Background(), s.newTicket(priorityInteractive), nil); err != errClosed {
+		t.Errorf("got error %v for a new fill, want %v", err, errClosed)
+	}
+
+	// The running fill is not affected.
+	s.release()
+	if active, queued := s.stats(); active != 0 || queued != 0 {
+		t.Errorf("got %v active and %v queued fills, want %v and %v", active, queued, 0, 0)
+	}
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// ScrubAction decides what happens to cached artifacts that fail verification.
+type ScrubAction string
+
+const (
+	// ScrubActionReport only reports corrupt artifacts.
+	ScrubActionReport ScrubAction = "report"
+	// ScrubActionQuarantine moves corrupt artifacts and their signatures to the quarantine directory of the cache.
+	ScrubActionQuarantine ScrubAction = "quarantine"
+	// ScrubActionDelete removes corrupt artifacts and their signatures.
+	ScrubActionDelete ScrubAction = "delete"
+)
+
+// Verification results of a cached artifact.
+const (
+	ScrubStatusOK         = "ok"
+	ScrubStatusCorrupt    = "corrupt"
+	ScrubStatusUnverified = "unverified" // neither listed in index.json nor signed
+	ScrubStatusError      = "error"
+)
+
+// Results of the signature verification of a cached artifact.
+const (
+	signatureValid      = "valid"
+	signatureInvalid    = "invalid"
+	signatureMissing    = "missing"
+	signatureNotChecked = "not-checked"
+)
+
+// Directory of the cache that quarantined artifacts are moved to.
+const quarantineDir = "quarantine"
+
+// ScrubOptions holds the settings of a cache scrub.
+type ScrubOptions struct {
+	Action ScrubAction
+	// BytesPerSecond limits how fast the artifacts are read, zero means unlimited.
+	BytesPerSecond int64
+}
+
+// ScrubResult is the verification result of a single cached artifact.
+type ScrubResult struct {
+	// Path is relative to the cache directory.
+	Path           string `json:"path"`
+	Size           int64  `json:"size"`
+	SHA256         string `json:"sha256,omitempty"`
+	ExpectedSHA256 string `json:"expected_sha256,omitempty"`
+	Signature      string `json:"signature"`
+	Status         string `json:"status"`
+	Reason         string `json:"reason,omitempty"`
+	// Action is what happened to a corrupt artifact: "reported", "quarantined" or "deleted".
+	Action string `json:"action,omitempty"`
+}
+
+// ScrubReport is the machine-readable result of a cache scrub.
+type ScrubReport struct {
+	CacheDir   string        `json:"cache_dir"`
+	Action     ScrubAction   `json:"action"`
+	Started    time.Time     `json:"started"`
+	Finished   time.Time     `json:"finished"`
+	Checked    int           `json:"checked"`
+	OK         int           `json:"ok"`
+	Corrupt    int           `json:"corrupt"`
+	Unverified int           `json:"unverified"`
+	Errors     int           `json:"errors"`
+	Results    []ScrubResult `json:"results"`
+}
+
+// Scrub re-hashes every cached artifact in download/ and builds/, compares it with the checksum and size from index.json,
+// verifies its signature if there is one, and applies the action to the artifacts that fail.
+// Without index.json only the signatures are verified.
+func (c *Cache) Scrub(ctx context.Context, opts ScrubOptions) (ScrubReport, error) {
+	report := ScrubReport{
+		CacheDir: c.cacheDir,
+		Action:   opts.Action,
+		Started:  time.Now(),
+		Results:  []ScrubResult{},
+	}
+
+	var releases zig.ZigReleases
+	if c.index != nil {
+		var err error
+		if releases, err = c.index.Releases(ctx); err != nil {
+			slog.Warn("failed to fetch index.json for the cache scrub, only signatures are verified", "error", err)
+		}
+	}
+
+	for _, dir := range []string{"download", "builds"} {
+		err := filepath.WalkDir(filepath.Join(c.cacheDir, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// Signatures are verified together with their artifacts, partial downloads and other files are skipped.
+			name := d.Name()
+			if d.IsDir() || !zig.IsZigArtifact(name) || strings.HasSuffix(name, ".minisig") {
+				return nil
+			}
+
+			result, ok := c.scrubFile(ctx, path, releases, opts.BytesPerSecond)
+			if !ok {
+				// The artifact was evicted or removed since the walk listed it.
+				return nil
+			}
+			if result.Status == ScrubStatusCorrupt {
+				result.Action = c.applyScrubAction(path, opts.Action)
+			}
+			c.metrics.ScrubbedFiles.Inc(result.Status)
+
+			report.add(result)
+			return nil
+		})
+		if err != nil {
+			report.Finished = time.Now()
+			return report, err
+		}
+	}
+
+	report.Finished = time.Now()
+	return report, nil
+}
+
+func (r *ScrubReport) add(result ScrubResult) {
+	r.Checked++
+	switch result.Status {
+	case ScrubStatusOK:
+		r.OK++
+	case ScrubStatusCorrupt:
+		r.Corrupt++
+	case ScrubStatusUnverified:
+		r.Unverified++
+	default:
+		r.Errors++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// Verifies a single cached artifact, it returns false if the artifact doesn't exist anymore.
+func (c *Cache) scrubFile(ctx context.Context, path string, releases zig.ZigReleases, bytesPerSecond int64) (ScrubResult, bool) {
+	result := ScrubResult{Path: c.relativePath(path), Signature: signatureNotChecked}
+
+	check, err := c.checkFile(ctx, path, path+".minisig", bytesPerSecond)
+	if errors.Is(err, fs.ErrNotExist) {
+		return result, false
+	}
+	if err != nil {
+		result.Status, result.Reason = ScrubStatusError, err.Error()
+		return result, true
+	}
+	result.Size, result.SHA256, result.Signature = check.size, check.sha256, check.signature
+
+	artifact, listed := releases.FindArtifact(filepath.Base(path))
+	listed = listed && artifact.Shasum != ""
+	if listed {
+		result.ExpectedSHA256 = strings.ToLower(artifact.Shasum)
+	}
+
+	switch {
+	case listed && artifact.Size != "" && artifact.Size != strconv.FormatInt(result.Size, 10):
+		result.Status = ScrubStatusCorrupt
+		result.Reason = fmt.Sprintf("size is %d bytes, index.json lists %s bytes", result.Size, artifact.Size)
+	case listed && result.SHA256 != result.ExpectedSHA256:
+		result.Status, result.Reason = ScrubStatusCorrupt, "checksum does not match index.json"
+	case result.Signature == signatureInvalid:
+		result.Status, result.Reason = ScrubStatusCorrupt, "signature verification failed"
+	case !listed && result.Signature != signatureValid:
+		result.Status, result.Reason = ScrubStatusUnverified, "not listed in index.json and not signed"
+	default:
+		result.Status = ScrubStatusOK
+	}
+
+	if result.Status == ScrubStatusCorrupt {
+		slog.Error("cached artifact failed verification", "path", path, "reason", result.Reason)
+	}
+	return result, true
+}
+
+// Size, SHA-256 checksum and signature status of a file.
+type fileCheck struct {
+	size      int64
+	sha256    string
+	signature string
+}
+
+// Hashes a file and verifies its signature in the same pass, so that it is only read once.
+// The signature is only verified if a public key is configured and signaturePath is not empty.
+func (c *Cache) checkFile(ctx context.Context, path, signaturePath string, bytesPerSecond int64) (fileCheck, error) {
+	check := fileCheck{signature: signatureNotChecked}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return check, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return check, err
+	}
+	check.size = info.Size()
+
+	var reader io.Reader = file
+	if bytesPerSecond > 0 {
+		reader = &pacedReader{r: file, ctx: ctx, pacer: newPacer(bytesPerSecond)}
+	}
+
+	hash := sha256.New()
+	reader = io.TeeReader(reader, hash)
+
+	var signature *zig.Signature
+	if c.publicKey != nil && signaturePath != "" {
+		signature, check.signature = readCachedSignature(signaturePath, filepath.Base(path))
+	}
+
+	var signatureErr error
+	if signature != nil {
+		signatureErr = c.publicKey.Verify(*signature, reader)
+	}
+	// Hash the rest of the file, or all of it if there is no signature.
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return check, err
+	}
+	check.sha256 = hex.EncodeToString(hash.Sum(nil))
+
+	if signature != nil {
+		switch {
+		case signatureErr == nil:
+			check.signature = signatureValid
+		case errors.Is(signatureErr, zig.ErrVerification) || errors.Is(signatureErr, zig.ErrKeyMismatch):
+			check.signature = signatureInvalid
+		default:
+			return check, signatureErr
+		}
+	}
+
+	return check, nil
+}
+
+// Reads the signature of an artifact, it returns the signature status if it can't be used.
+func readCachedSignature(path, filename string) (*zig.Signature, string) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, signatureMissing
+	}
+
+	signature, err := zig.ParseSignature(raw)
+	if err != nil {
+		return nil, signatureInvalid
+	}
+	if file := signature.File(); file != "" && file != filename {
+		return nil, signatureInvalid
+	}
+	return &signature, ""
+}
+
+// Quarantines or deletes a corrupt artifact together with its signature, and returns what was done.
+func (c *Cache) applyScrubAction(path string, action ScrubAction) string {
+	logger := slog.With("path", path)
+
+	for _, name := range []string{path, path + ".minisig"} {
+		var err error
+		switch action {
+		case ScrubActionQuarantine:
+			destination := filepath.Join(c.cacheDir, quarantineDir, c.relativePath(name))
+			if err = os.MkdirAll(filepath.Dir(destination), 0755); err == nil {
+				err = c.evictor.track(func() error { return os.Rename(name, destination) }, name)
+			}
+		case ScrubActionDelete:
+			err = c.evictor.track(func() error { return os.Remove(name) }, name)
+		default:
+			return "reported"
+		}
+
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("failed to remove a corrupt artifact from the cache", "action", action, "error", err)
+			return "failed"
+		}
+	}
+
+	if action == ScrubActionQuarantine {
+		logger.Warn("moved a corrupt artifact to the quarantine directory")
+		return "quarantined"
+	}
+	logger.Warn("deleted a corrupt artifact")
+	return "deleted"
+}
+
+// Returns the path relative to the cache directory with forward slashes.
+func (c *Cache) relativePath(path string) string {
+	if rel, err := filepath.Rel(c.cacheDir, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// A reader that limits the read rate.
+type pacedReader struct {
+	r     io.Reader
+	ctx   context.Context
+	pacer pacer
+}
+
+func (r *pacedReader) Read(p []byte) (int, error) {
+	if err := r.pacer.wait(r.ctx); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.Read(p[:min(int64(len(p)), r.pacer.rate)])
+	r.pacer.add(n)
+	return n, err
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+func TestCacheScrub(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := zig.ParsePublicKey(base64.StdEncoding.EncodeToString(
+		bytes.Join([][]byte{[]byte("Ed"), {1, 2, 3, 4, 5, 6, 7, 8}, pub}, nil),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const devBuild = "builds/zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz"
+	stable := "download/0.14.1/" + testArtifact
+
+	tests := []struct {
+		name           string
+		path           string
+		content        string
+		signedContent  string // empty means no signature
+		action         ScrubAction
+		expectedStatus string
+		expectedAction string
+		expectKept     bool
+		expectMovedTo  string
+	}{
+		{"intact artifact", stable, "zig tarball", "zig tarball", ScrubActionReport, ScrubStatusOK, "", true, ""},
+		{"checksum mismatch", stable, "zig tarbalL", "", ScrubActionReport, ScrubStatusCorrupt, "reported", true, ""},
+		{"size mismatch", stable, "zig tarball!", "zig tarball!", ScrubActionQuarantine, ScrubStatusCorrupt, "quarantined", false, "quarantine/" + stable},
+		{"invalid signature", stable, "zig tarball", "other tarball", ScrubActionDelete, ScrubStatusCorrupt, "deleted", false, ""},
+		{"unlisted and unsigned", devBuild, "zig dev tarball", "", ScrubActionDelete, ScrubStatusUnverified, "", true, ""},
+		{"unlisted and signed", devBuild, "zig dev tarball", "zig dev tarball", ScrubActionDelete, ScrubStatusOK, "", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			upstream := newTestUpstream(t, "", "zig tarball")
+			cacheDir := t.TempDir()
+
+			writeFile := func(name, content string) {
+				t.Helper()
+				path := filepath.Join(cacheDir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			writeFile(tt.path, tt.content)
+			if tt.signedContent != "" {
+				writeFile(tt.path+".minisig", string(testSign(t, priv, []byte(tt.signedContent), filepath.Base(tt.path))))
+			}
+			// Partial downloads and index.json are not verified.
+			writeFile("download/0.14.1/zig-aarch64-linux-0.14.1.tar.xz.partial", "zig")
+			writeFile("download/index.json", "{}")
+
+			cache := NewCache(CacheOptions{
+				UpstreamHost: upstream.URL,
+				CacheDir:     cacheDir,
+				Index:        zig.NewIndex([]string{upstream.URL + "/download/index.json"}, time.Minute, ""),
+				PublicKey:    &publicKey,
+			})
+
+			report, err := cache.Scrub(context.Background(), ScrubOptions{Action: tt.action})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Checked != 1 || len(report.Results) != 1 {
+				t.Fatalf("got %v checked artifacts, want 1", report.Checked)
+			}
+			result := report.Results[0]
+			if result.Path != tt.path {
+				t.Errorf("got path %v, want %v", result.Path, tt.path)
+			}
+			if result.Status != tt.expectedStatus {
+				t.Errorf("got status %v (%v), want %v", result.Status, result.Reason, tt.expectedStatus)
+			}
+			if result.Action != tt.expectedAction {
+				t.Errorf("got action %v, want %v", result.Action, tt.expectedAction)
+			}
+
+			if kept := fileExists(filepath.Join(cacheDir, tt.path)); kept != tt.expectKept {
+				t.Errorf("got kept %v, want %v", kept, tt.expectKept)
+			}
+			if tt.signedContent != "" {
+				if kept := fileExists(filepath.Join(cacheDir, tt.path+".minisig")); kept != tt.expectKept {
+					t.Errorf("got kept signature %v, want %v", kept, tt.expectKept)
+				}
+			}
+			if tt.expectMovedTo != "" {
+				if !fileExists(filepath.Join(cacheDir, tt.expectMovedTo)) || !fileExists(filepath.Join(cacheDir, tt.expectMovedTo+".minisig")) {
+					t.Errorf("got no quarantined artifact and signature at %v", tt.expectMovedTo)
+				}
+			}
+		})
+	}
+}
+
+func TestPacedReader(t *testing.T) {
+	t.Parallel()
+
+	const rate = 64 << 10
+	r := &pacedReader{r: bytes.NewReader(make([]byte, rate/2)), ctx: context.Background(), pacer: newPacer(rate)}
+
+	start := time.Now()
+	if _, err := bytes.NewBuffer(nil).ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	// Reading half a second worth of data can't be much faster than that.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("got %v, want at least 400ms", elapsed)
+	}
+}
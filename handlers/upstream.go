@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpstreamStrategy decides in which order upstreams are tried.
+type UpstreamStrategy string
+
+const (
+	// UpstreamOrdered tries upstreams in the configured order, the canonical one first.
+	UpstreamOrdered UpstreamStrategy = "ordered"
+	// UpstreamHealth tries upstreams with the best recent success rate first.
+	UpstreamHealth UpstreamStrategy = "health"
+)
+
+// Number of consecutive failures after which an upstream is put into cooldown.
+const upstreamFailureThreshold = 3
+
+// Weight of the latest result in the health score of an upstream.
+const upstreamScoreWeight = 0.2
+
+// upstream is a source the cache fills artifacts from.
+// The canonical upstream (ziglang.org) uses the download/<version>/ and builds/ directory structure,
+// community mirrors serve every artifact at the root of their URL.
+// See: https://ziglang.org/download/community-mirrors/
+type upstream struct {
+	url       string
+	canonical bool
+
+	mu             sync.Mutex
+	score          float64 // success rate of recent fills, from 0 to 1
+	failures       int     // consecutive failures
+	unhealthyUntil time.Time
+}
+
+// Returns the URL of an artifact on this upstream.
+func (u *upstream) artifactURL(filename, version string) string {
+	if !u.canonical {
+		return fmt.Sprintf("%s/%s?source=go-mirror-zig", u.url, url.PathEscape(filename))
+	}
+	if strings.Contains(version, "-dev") {
+		return fmt.Sprintf("%s/builds/%s", u.url, filename)
+	}
+	return fmt.Sprintf("%s/download/%s/%s", u.url, version, filename)
+}
+
+func (u *upstream) healthy(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return now.After(u.unhealthyUntil)
+}
+
+func (u *upstream) healthScore() float64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.score
+}
+
+// upstreamPool tracks the health of all configured upstreams.
+type upstreamPool struct {
+	upstreams []*upstream
+	strategy  UpstreamStrategy
+	cooldown  time.Duration
+}
+
+// Creates a pool with the canonical upstream first, followed by the mirrors in their configured order.
+func newUpstreamPool(canonical string, mirrors []string, strategy UpstreamStrategy, cooldown time.Duration) *upstreamPool {
+	p := &upstreamPool{
+		strategy: strategy,
+		cooldown: cooldown,
+	}
+
+	p.upstreams = append(p.upstreams, &upstream{url: strings.TrimSuffix(canonical, "/"), canonical: true, score: 1})
+	for _, mirror := range mirrors {
+		p.upstreams = append(p.upstreams, &upstream{url: strings.TrimSuffix(mirror, "/"), score: 1})
+	}
+
+	return p
+}
+
+// Returns the upstreams in the order they should be tried.
+// Upstreams in cooldown come last, they are still tried if every other upstream fails.
+func (p *upstreamPool) candidates() []*upstream {
+	now := time.Now()
+
+	healthy := make([]*upstream, 0, len(p.upstreams))
+	var unhealthy []*upstream
+	for _, u := range p.upstreams {
+		if u.healthy(now) {
+			healthy = append(healthy, u)
+		} else {
+			unhealthy = append(unhealthy, u)
+		}
+	}
+
+	if p.strategy == UpstreamHealth {
+		slices.SortStableFunc(healthy, func(a, b *upstream) int {
+			sa, sb := a.healthScore(), b.healthScore()
+			switch {
+			case sa > sb:
+				return -1
+			case sa < sb:
+				return 1
+			}
+			return 0
+		})
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// Records a successful fill from the upstream.
+func (p *upstreamPool) markSuccess(u *upstream) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.score = (1-upstreamScoreWeight)*u.score + upstreamScoreWeight
+	u.failures = 0
+	u.unhealthyUntil = time.Time{}
+}
+
+// Records a failed fill from the upstream and puts it into cooldown once it keeps failing.
+func (p *upstreamPool) markFailure(logger *slog.Logger, u *upstream) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.score = (1 - upstreamScoreWeight) * u.score
+	u.failures++
+
+	if u.failures >= upstreamFailureThreshold && time.Now().After(u.unhealthyUntil) {
+		u.unhealthyUntil = time.Now().Add(p.cooldown)
+		logger.Warn("upstream keeps failing, marking it unhealthy", "upstream", u.url, "consecutive_failures", u.failures, "cooldown", p.cooldown)
+	}
+}
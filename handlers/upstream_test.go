@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUpstreamArtifactURL(t *testing.T) {
+	t.Parallel()
+
+	canonical := &upstream{url: "https://ziglang.org", canonical: true}
+	mirror := &upstream{url: "https://mirror.example.com/zig"}
+
+	tests := []struct {
+		name     string
+		u        *upstream
+		filename string
+		version  string
+		expected string
+	}{
+		{"canonical release", canonical, "zig-x86_64-linux-0.14.1.tar.xz", "0.14.1", "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz"},
+		{"canonical dev build", canonical, "zig-0.17.0-dev.305+bdfbf432d.tar.xz", "0.17.0-dev.305+bdfbf432d", "https://ziglang.org/builds/zig-0.17.0-dev.305+bdfbf432d.tar.xz"},
+		{"mirror release", mirror, "zig-x86_64-linux-0.14.1.tar.xz", "0.14.1", "https://mirror.example.com/zig/zig-x86_64-linux-0.14.1.tar.xz?source=go-mirror-zig"},
+		{"mirror dev build", mirror, "zig-0.17.0-dev.305+bdfbf432d.tar.xz", "0.17.0-dev.305+bdfbf432d", "https://mirror.example.com/zig/zig-0.17.0-dev.305+bdfbf432d.tar.xz?source=go-mirror-zig"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.u.artifactURL(tt.filename, tt.version); got != tt.expected {
+				t.Errorf("got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUpstreamPoolCandidates(t *testing.T) {
+	t.Parallel()
+
+	urls := func(upstreams []*upstream) []string {
+		var out []string
+		for _, u := range upstreams {
+			out = append(out, u.url)
+		}
+		return out
+	}
+
+	t.Run("ordered with cooldown", func(t *testing.T) {
+		t.Parallel()
+
+		p := newUpstreamPool("https://a", []string{"https://b", "https://c"}, UpstreamOrdered, time.Hour)
+		for range upstreamFailureThreshold {
+			p.markFailure(slog.Default(), p.upstreams[0])
+		}
+
+		got := urls(p.candidates())
+		expected := []string{"https://b", "https://c", "https://a"}
+		if !slices.Equal(got, expected) {
+			t.Errorf("got %v, want %v", got, expected)
+		}
+
+		p.markSuccess(p.upstreams[0])
+		if got := urls(p.candidates()); got[0] != "https://a" {
+			t.Errorf("got %v, want https://a first after a success", got)
+		}
+	})
+
+	t.Run("health score", func(t *testing.T) {
+		t.Parallel()
+
+		p := newUpstreamPool("https://a", []string{"https://b", "https://c"}, UpstreamHealth, time.Hour)
+		p.markFailure(slog.Default(), p.upstreams[0])
+		p.markFailure(slog.Default(), p.upstreams[1])
+		p.markFailure(slog.Default(), p.upstreams[1])
+
+		got := urls(p.candidates())
+		expected := []string{"https://c", "https://a", "https://b"}
+		if !slices.Equal(got, expected) {
+			t.Errorf("got %v, want %v", got, expected)
+		}
+	})
+}
+
+func TestCacheUpstreamFailover(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		canonicalStatus int
+		mirrorStatus    int
+		expectedStatus  int
+	}{
+		{"canonical serves the file", http.StatusOK, http.StatusInternalServerError, http.StatusOK},
+		{"canonical fails, mirror serves the file", http.StatusServiceUnavailable, http.StatusOK, http.StatusOK},
+		{"canonical misses the file, mirror serves it", http.StatusNotFound, http.StatusOK, http.StatusOK},
+		{"nobody has the file", http.StatusNotFound, http.StatusNotFound, http.StatusNotFound},
+		{"everybody fails", http.StatusServiceUnavailable, http.StatusNotFound, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			canonical := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/download/0.14.1/"+testArtifact {
+					t.Errorf("unexpected canonical path %v", r.URL.Path)
+				}
+				w.WriteHeader(tt.canonicalStatus)
+				w.Write([]byte("zig tarball"))
+			}))
+			defer canonical.Close()
+
+			mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/"+testArtifact {
+					t.Errorf("unexpected mirror path %v", r.URL.Path)
+				}
+				w.WriteHeader(tt.mirrorStatus)
+				w.Write([]byte("zig tarball"))
+			}))
+			defer mirror.Close()
+
+			cacheDir := t.TempDir()
+			cache := NewCache(CacheOptions{
+				CacheDir:     cacheDir,
+				UpstreamHost: canonical.URL,
+				Mirrors:      []string{mirror.URL},
+				Strategy:     UpstreamOrdered,
+				Unlisted:     UnlistedAccept,
+			})
+
+			if status := fetchFromMirror(t, cache, "/download/0.14.1/"+testArtifact, "zig tarball"); status != tt.expectedStatus {
+				t.Errorf("got status %v, want %v", status, tt.expectedStatus)
+			}
+
+			cached := fileExists(filepath.Join(cacheDir, "download", "0.14.1", testArtifact))
+			if cached != (tt.expectedStatus == http.StatusOK) {
+				t.Errorf("got cached %v, want %v", cached, tt.expectedStatus == http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// Upper bound for the size of a .minisig file, real ones are a few hundred bytes.
+const maxSignatureSize = 64 << 10
+
+// Returns the SHA-256 checksum the downloaded file is expected to have.
+// An empty string means the file can't be verified (signatures, or unlisted artifacts accepted by the policy).
+// The policy only applies to artifacts that are missing from a loaded index.json, a failed fetch fails the fill.
+func (c *Cache) expectedShasum(ctx context.Context, logger *slog.Logger, filename string) (string, error) {
+	// Signatures are not listed in index.json, they are verified by their own means.
+	if strings.HasSuffix(filename, ".minisig") {
+		return "", nil
+	}
+
+	var artifact zig.Artifact
+	var listed bool
+	if c.index != nil {
+		var err error
+		artifact, listed, err = c.index.Lookup(ctx, filename)
+		if err != nil {
+			// Without index.json it is unknown whether the artifact is listed, the unlisted policy doesn't apply.
+			logger.Error("failed to fetch index.json for checksum verification", "error", err)
+			return "", fmt.Errorf("%w: %w", errUpstreamUnavailable, err)
+		}
+	}
+
+	if listed && artifact.Shasum != "" {
+		return strings.ToLower(artifact.Shasum), nil
+	}
+
+	if c.unlisted == UnlistedReject {
+		logger.Warn("refusing to cache an artifact that is not listed in index.json")
+		return "", errUnlistedArtifact
+	}
+
+	logger.Warn("artifact is not listed in index.json, caching it without checksum verification")
+	return "", nil
+}
+
+// Fetches and parses the .minisig file of an artifact.
+func (c *Cache) fetchSignature(ctx context.Context, logger *slog.Logger, signatureURL, filename string) (*zig.Signature, []byte, error) {
+	logger = logger.With("signature_url", signatureURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, signatureURL, nil)
+	if err != nil {
+		logger.Error("failed to create upstream request", "error", err)
+		return nil, nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		logger.Error("failed to fetch the artifact signature from upstream", "error", err)
+		return nil, nil, errUpstreamUnavailable
+	}
+	defer resp.Body.Close()
+	c.recordUpstreamResponse(signatureURL, resp.StatusCode)
+
+	if resp.StatusCode == http.StatusNotFound {
+		logger.Error("refusing to cache an artifact without a signature")
+		return nil, nil, errSignatureInvalid
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("upstream server returned non-OK status", "status_code", resp.StatusCode)
+		return nil, nil, errUpstreamUnavailable
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		logger.Error("failed to read the artifact signature", "error", err)
+		return nil, nil, errUpstreamUnavailable
+	}
+
+	signature, err := zig.ParseSignature(raw)
+	if err != nil {
+		logger.Error("failed to parse the artifact signature", "error", err)
+		return nil, nil, errSignatureInvalid
+	}
+
+	// Zig signs the file name as well, a signature of a different artifact must not be accepted.
+	if file := signature.File(); file != "" && file != filename {
+		logger.Error("artifact signature was made for a different file", "signed_file", file)
+		return nil, nil, errSignatureInvalid
+	}
+
+	return &signature, raw, nil
+}
+
+// Verifies the downloaded file against its signature.
+func (c *Cache) verifySignature(logger *slog.Logger, signature zig.Signature, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Error("failed to open the downloaded file for signature verification", "path", path, "error", err)
+		return err
+	}
+	defer file.Close()
+
+	if err := c.publicKey.Verify(signature, file); err != nil {
+		if errors.Is(err, zig.ErrVerification) || errors.Is(err, zig.ErrKeyMismatch) {
+			logger.Error("downloaded file failed signature verification", "error", err)
+			return fmt.Errorf("%w: %w", errSignatureInvalid, err)
+		}
+
+		logger.Error("failed to read the downloaded file for signature verification", "path", path, "error", err)
+		return err
+	}
+
+	return nil
+}
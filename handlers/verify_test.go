@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signs data the same way Zig release signatures are made (prehashed minisign).
+func testSign(t *testing.T, priv ed25519.PrivateKey, data []byte, file string) []byte {
+	t.Helper()
+
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	trustedComment := "timestamp:1718830745\tfile:" + file + "\thashed"
+
+	sum := blake2b.Sum512(data)
+	signature := ed25519.Sign(priv, sum[:])
+	global := ed25519.Sign(priv, append(bytes.Clone(signature), trustedComment...))
+
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(bytes.Join([][]byte{[]byte("ED"), keyID, signature}, nil)) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+func TestCacheHandlerSignature(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := zig.ParsePublicKey(base64.StdEncoding.EncodeToString(
+		bytes.Join([][]byte{[]byte("Ed"), {1, 2, 3, 4, 5, 6, 7, 8}, pub}, nil),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("zig tarball")
+
+	tests := []struct {
+		name           string
+		signature      []byte
+		expectedStatus int
+		expectCached   bool
+	}{
+		{
+			name:           "valid signature",
+			signature:      testSign(t, priv, content, testArtifact),
+			expectedStatus: http.StatusOK,
+			expectCached:   true,
+		},
+		{
+			name:           "signature of different content",
+			signature:      testSign(t, priv, []byte("other tarball"), testArtifact),
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "signature of a different file",
+			signature:      testSign(t, priv, content, "zig-x86_64-linux-0.14.0.tar.xz"),
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "malformed signature",
+			signature:      []byte("not a signature"),
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "missing signature",
+			signature:      nil,
+			expectedStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("/download/0.14.1/"+testArtifact, func(w http.ResponseWriter, r *http.Request) {
+				w.Write(content)
+			})
+			mux.HandleFunc("/download/0.14.1/"+testArtifact+".minisig", func(w http.ResponseWriter, r *http.Request) {
+				if tt.signature == nil {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write(tt.signature)
+			})
+			upstream := httptest.NewServer(mux)
+			defer upstream.Close()
+
+			cacheDir := t.TempDir()
+			cache := NewCache(CacheOptions{
+				UpstreamHost: upstream.URL,
+				CacheDir:     cacheDir,
+				Unlisted:     UnlistedAccept,
+				PublicKey:    &publicKey,
+			})
+
+			status := fetchFromMirror(t, cache, "/download/0.14.1/"+testArtifact, string(content))
+			if status != tt.expectedStatus {
+				t.Errorf("got status %v, want %v", status, tt.expectedStatus)
+			}
+
+			dir := filepath.Join(cacheDir, "download", "0.14.1")
+			if cached := fileExists(filepath.Join(dir, testArtifact)); cached != tt.expectCached {
+				t.Errorf("got cached %v, want %v", cached, tt.expectCached)
+			}
+			if cached := fileExists(filepath.Join(dir, testArtifact+".minisig")); cached != tt.expectCached {
+				t.Errorf("got cached signature %v, want %v", cached, tt.expectCached)
+			}
+		})
+	}
+}
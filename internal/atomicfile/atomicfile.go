@@ -0,0 +1,28 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to a temporary file next to name (<name>.*.tmp) and renames it to name once it is complete,
+// so that readers see either the previous or the new contents, never a partially written file.
+func WriteFile(name string, data []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+	}
+
+	return err
+}
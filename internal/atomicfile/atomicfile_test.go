@@ -0,0 +1,50 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		existing string // empty means no previous file
+		expected string
+	}{
+		{"new file", "", "zig"},
+		{"replaced file", "old contents", "zig"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			name := filepath.Join(dir, "index.json")
+			if tt.existing != "" {
+				if err := os.WriteFile(name, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := WriteFile(name, []byte(tt.expected)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := os.ReadFile(name); err != nil || string(got) != tt.expected {
+				t.Errorf("got (%q, %v), want %q", got, err, tt.expected)
+			}
+			// No temporary file is left behind.
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("got %v files, want %v", len(entries), 1)
+			}
+		})
+	}
+
+	if err := WriteFile(filepath.Join(t.TempDir(), "missing", "index.json"), []byte("zig")); err == nil {
+		t.Error("got no error for a missing directory, want one")
+	}
+}
@@ -6,17 +6,34 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"net/netip"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
 )
 
 // Config holds configuration values, populated from command-line flags.
 type Config struct {
 	CacheDir         string
 	UpstreamURL      string
+	UpstreamMirrors  []string
+	UpstreamStrategy string
+	UpstreamCooldown int
 	HTTPPort         int
 	TLSPort          int
 	ListenAddress    string
+	// MetricsAddress is the address of the separate Prometheus metrics listener, empty disables it.
+	MetricsAddress string
+	// AccessLog is the path of the access log file, "-" writes to stdout and empty disables it.
+	AccessLog       string
+	AccessLogFormat string
+	// TrustedProxies are the networks of reverse proxies whose X-Forwarded-For, Forwarded and X-Real-IP headers are trusted.
+	TrustedProxies   []netip.Prefix
 	EnableTLS        bool
 	RedirectToHTTPS  bool
 	ShowVersion      bool
@@ -24,6 +41,68 @@ type Config struct {
 	ShowIndexPage    bool
 	IndexPage        string
 	ClearBuilds      int
+	SyncInterval     int
+	Prefetch         bool
+	PrefetchJobs     int
+	// Offline serves only cached files and never contacts an upstream, the index.json stored in the cache directory is used.
+	Offline bool
+	// Versions and Platforms select the artifacts to prefetch and sync, empty means all.
+	Versions    []string
+	Platforms   []string
+	FillTimeout int
+	// WriteTimeout limits the responses that are not artifact downloads, in seconds.
+	WriteTimeout int
+	// DownloadIdleTimeout is the time in seconds an artifact download can go without progress,
+	// DownloadTimeout limits the whole download, zero means no limit.
+	DownloadIdleTimeout int
+	DownloadTimeout     int
+	// Recovery checks the cache directory on startup, RecoveryGracePeriod is the age in seconds of the temporary files it removes.
+	Recovery            bool
+	RecoveryGracePeriod int
+	RecoveryFix         bool
+	// DrainDelay is the time in seconds /readyz reports draining before the listeners stop on shutdown,
+	// DrainTimeout is the time in seconds downloads and upstream fills get to finish afterwards.
+	DrainDelay   int
+	DrainTimeout int
+	// MaxFills is the number of concurrent upstream downloads, zero means unlimited.
+	MaxFills int
+	IndexTTL int
+	// PublicURL is the base URL of this mirror used in the served index.json, empty keeps the upstream URLs.
+	PublicURL string
+	// MinFreeSpace is the free space in bytes below which the server is not ready, zero disables the check.
+	MinFreeSpace int64
+	// IndexMaxAge is the interval in seconds after the last successful index.json fetch the server is not ready anymore.
+	IndexMaxAge int
+	// MaxCacheSize is the size limit of the cache in bytes, zero means unlimited.
+	MaxCacheSize   int64
+	EvictionPolicy string
+	// RateLimit is the sustained number of requests per second of a client, zero disables the limit.
+	RateLimit      float64
+	RateLimitBurst int
+	// MaxClientDownloads is the number of artifacts a client can download at the same time, zero means unlimited.
+	MaxClientDownloads int
+	// MaxBytesPerSecond is the transfer rate limit of every response, zero means unlimited.
+	MaxBytesPerSecond int64
+	// UnlistedArtifacts is the policy for artifacts missing from the upstream index.json: "accept" or "reject".
+	UnlistedArtifacts string
+	VerifySignatures  bool
+	MinisignKey       string
+	// Verify re-hashes all cached artifacts, writes a report to VerifyReport and exits.
+	Verify bool
+	// VerifyAction is what happens to cached artifacts that fail verification: "report", "quarantine" or "delete".
+	VerifyAction string
+	// VerifyReport is the path of the JSON verification report, "-" writes it to stdout.
+	VerifyReport string
+	// ScrubInterval is the interval in seconds of the background verification of the cache, zero disables it.
+	ScrubInterval int
+	// ScrubRate is the read rate in bytes per second of the background verification, zero means unlimited.
+	ScrubRate int64
+	// Import lists the files and directories whose Zig artifacts are imported into the cache before exiting.
+	Import []string
+	// Export is the path of the bundle of the -versions and -platforms artifacts that is written before exiting.
+	Export string
+	// ImportMethod is how imported files are placed in the cache: "auto", "hardlink", "reflink" or "copy".
+	ImportMethod string
 
 	ACME          bool
 	ACMEDirectory string
@@ -36,13 +115,39 @@ type Config struct {
 	// KeyPair is the loaded TLS certificate and key. It is only populated if EnableTLS is true.
 	KeyPair tls.Certificate
 
+	// PrintConfig prints the effective configuration and exits.
+	PrintConfig bool
+
+	// PublicKey is the parsed MinisignKey. It is only populated if VerifySignatures is true.
+	PublicKey zig.PublicKey
+
+	// effective holds the value of every flag after merging all sources.
+	effective map[string]any
+
 	// unexported fields for parsing flags before validation.
+	configFile  string
 	tlsCertFile string
 	tlsKeyFile  string
+
+	upstreamMirrors string
+	trustedProxies  string
+	maxCacheSize    string
+	minFreeSpace    string
+	maxBytesPerSec  string
+	scrubRate       string
+	importPaths     string
+	versions        string
+	platforms       string
 }
 
 // ParseConfig defines and parses command-line flags, validates them, and returns a populated Config struct.
+// Flags that are not set on the command line are taken from ZIGMIRROR_* environment variables,
+// then from the JSON config file of -config, then from the defaults.
 func ParseConfig(args []string, errorHandling flag.ErrorHandling) (Config, error) {
+	return parseConfig(args, errorHandling, os.LookupEnv)
+}
+
+func parseConfig(args []string, errorHandling flag.ErrorHandling, lookupEnv func(string) (string, bool)) (Config, error) {
 	var c Config
 
 	fs := flag.NewFlagSet("go-mirror-zig", errorHandling)
@@ -51,11 +156,20 @@ func ParseConfig(args []string, errorHandling flag.ErrorHandling) (Config, error
 		fs.SetOutput(io.Discard) // suppress console text on tests
 	}
 
+	fs.StringVar(&c.configFile, "config", "", "Path to a JSON config file with flag names as keys, e.g. {\"cache-dir\": \"/zig-mirror\"}. Command-line flags and ZIGMIRROR_* environment variables take precedence.")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "Print the effective configuration of all sources as a JSON config file, with credentials redacted, and exit.")
 	fs.StringVar(&c.CacheDir, "cache-dir", "./", "Path to the directory where downloaded content will be cached.")
 	fs.StringVar(&c.UpstreamURL, "upstream-url", "https://ziglang.org", "The URL of the upstream server to mirror/proxy.")
+	fs.StringVar(&c.upstreamMirrors, "upstream-mirrors", "", "Comma-separated list of community mirror URLs used when the upstream server fails. Mirrors only serve artifacts, index.json is always fetched from -upstream-url.")
+	fs.StringVar(&c.UpstreamStrategy, "upstream-strategy", "ordered", "The order in which upstreams are tried: \"ordered\" (-upstream-url first, then mirrors as listed) or \"health\" (best recent success rate first).")
+	fs.IntVar(&c.UpstreamCooldown, "upstream-cooldown", 300, "Interval in seconds an upstream that keeps failing is skipped.")
 	fs.IntVar(&c.HTTPPort, "http-port", 80, "The port for the plain HTTP listener.")
 	fs.IntVar(&c.TLSPort, "tls-port", 443, "The port for the secure TLS (HTTPS) listener.")
 	fs.StringVar(&c.ListenAddress, "listen-address", "", "The IP address to listen on. If empty, listens on all available interfaces.")
+	fs.StringVar(&c.MetricsAddress, "metrics-address", "", "The address (e.g. 127.0.0.1:9100) of a separate listener serving Prometheus metrics at /metrics. If empty, metrics are disabled.")
+	fs.StringVar(&c.AccessLog, "access-log", "", "Path of the access log file, \"-\" writes it to stdout. If empty, the access log is disabled.")
+	fs.StringVar(&c.AccessLogFormat, "access-log-format", "json", "The format of the access log: \"json\", \"logfmt\" or \"combined\" (Apache combined log format).")
+	fs.StringVar(&c.trustedProxies, "trusted-proxies", "", "Comma-separated list of IP addresses or CIDR ranges (e.g. 127.0.0.1,10.0.0.0/8) of reverse proxies whose X-Forwarded-For, Forwarded and X-Real-IP headers are used to determine the client IP. If empty, these headers are ignored.")
 	fs.BoolVar(&c.EnableTLS, "enable-tls", false, "Enable the TLS (HTTPS) server. Requires -tls-cert-file and -tls-key-file.")
 	fs.StringVar(&c.tlsCertFile, "tls-cert-file", "", "Path to the TLS certificate file.")
 	fs.StringVar(&c.tlsKeyFile, "tls-key-file", "", "Path to the TLS private key file.")
@@ -64,8 +178,46 @@ func ParseConfig(args []string, errorHandling flag.ErrorHandling) (Config, error
 	fs.BoolVar(&c.ShowPossibleSize, "show-possible-size", false, "Print estimation stats of all cacheable upstream artifacts (size, release counts) and exit.")
 	fs.BoolVar(&c.ShowIndexPage, "show-index-page", true, "Whether to serve a custom index page at the root (/). Set to false to disable.")
 	fs.StringVar(&c.IndexPage, "index-page", "", "Path to a directory containing static files to serve as the root index. If empty, uses the default built-in index page.")
+	fs.BoolVar(&c.Prefetch, "prefetch", false, "Download all artifacts selected by -versions and -platforms into the cache and exit.")
+	fs.StringVar(&c.versions, "versions", "", "Comma-separated list of releases to prefetch or export, e.g. 0.13.0,0.14.1,master. If empty, all releases are selected.")
+	fs.StringVar(&c.platforms, "platforms", "", "Comma-separated list of platforms to prefetch, sync or export, e.g. x86_64-linux,aarch64-macos,src. If empty, all platforms are selected.")
+	fs.BoolVar(&c.Offline, "offline", false, "Serve only cached files and never contact an upstream. Files that are not cached get a fast 404 or 503, index.json and the cleanup of dev builds use the index.json stored in the cache directory.")
+	fs.IntVar(&c.PrefetchJobs, "prefetch-jobs", 4, "Maximum number of artifacts downloaded in parallel by -prefetch.")
 	fs.IntVar(&c.ClearBuilds, "clear-builds-interval", 7200, "Interval in seconds to clean up cached dev builds. Set to 0 to disable.")
 
+	fs.IntVar(&c.SyncInterval, "sync-interval", 0, "Interval in seconds to poll the upstream index.json and download the -platforms artifacts of new releases and the current master build. Set to 0 to disable.")
+
+	fs.IntVar(&c.FillTimeout, "fill-timeout", 1800, "Maximum duration in seconds of a single upstream download. Downloads run in the background and are not cancelled when the requesting client disconnects.")
+	fs.IntVar(&c.WriteTimeout, "write-timeout", 10, "Maximum duration in seconds of writing a response that is not an artifact download, e.g. the index page, index.json and the health checks.")
+	fs.IntVar(&c.DownloadIdleTimeout, "download-idle-timeout", 60, "Maximum duration in seconds an artifact download can go without progress, e.g. because the client stopped reading. The deadline is extended while data keeps flowing, so slow clients can finish large downloads.")
+	fs.IntVar(&c.DownloadTimeout, "download-timeout", 0, "Maximum total duration in seconds of an artifact download. Set to 0 for no limit.")
+	fs.BoolVar(&c.Recovery, "recovery", true, "Check the cache directory on startup before the listeners start. Stale temporary files and partial downloads that can't be resumed are removed, misplaced artifacts and unknown files are reported.")
+	fs.IntVar(&c.RecoveryGracePeriod, "recovery-grace-period", 600, "Minimum age in seconds of the temporary files removed by -recovery, younger ones may still be in use.")
+	fs.BoolVar(&c.RecoveryFix, "recovery-fix", false, "Move the misplaced artifacts and signatures found by -recovery into the directory of their version.")
+	fs.IntVar(&c.DrainDelay, "drain-delay", 0, "Duration in seconds /readyz reports draining on shutdown while new connections are still accepted, so that load balancers can take the server out of rotation before the drain starts.")
+	fs.IntVar(&c.DrainTimeout, "drain-timeout", 60, "Maximum duration in seconds of the drain on shutdown, after -drain-delay. New connections are refused, queued upstream fills are cancelled, and downloads and upstream fills in progress can finish until the timeout.")
+	fs.IntVar(&c.MaxFills, "max-concurrent-fills", 8, "Maximum number of concurrent upstream downloads. Further downloads are queued, downloads clients are waiting for go before -prefetch and -sync-interval downloads. Set to 0 for no limit.")
+	fs.IntVar(&c.IndexTTL, "index-ttl", 300, "Interval in seconds the cached upstream index.json is considered fresh. An expired copy is still served while it is refreshed.")
+	fs.StringVar(&c.PublicURL, "public-url", "", "The public base URL of this mirror (e.g. https://zig.example.com). If set, tarball URLs in the served index.json point at this mirror.")
+	fs.StringVar(&c.minFreeSpace, "readiness-min-free-space", "1G", "Minimum free space in the cache directory, e.g. 500M or 10G, below which /readyz reports the server as not ready. Set to 0 to disable.")
+	fs.IntVar(&c.IndexMaxAge, "readiness-index-max-age", 86400, "Interval in seconds after the last successful upstream index.json fetch /readyz reports the server as not ready. Set to 0 to disable.")
+	fs.StringVar(&c.maxCacheSize, "max-cache-size", "0", "Maximum size of the cache, e.g. 500G or 2T. Artifacts are evicted once it is exceeded. Set to 0 to disable.")
+	fs.StringVar(&c.EvictionPolicy, "eviction-policy", "lru", "Which artifacts are evicted first once -max-cache-size is exceeded: \"lru\" (least recently used), \"lfu\" (least frequently used) or \"oldest-version\".")
+	fs.Float64Var(&c.RateLimit, "rate-limit", 0, "Sustained number of requests per second per client IP. Clients over the limit get 429 Too Many Requests. Set to 0 to disable.")
+	fs.IntVar(&c.RateLimitBurst, "rate-limit-burst", 20, "Number of requests a client IP can make at once before -rate-limit applies.")
+	fs.IntVar(&c.MaxClientDownloads, "max-downloads-per-client", 0, "Maximum number of artifacts a client IP can download at the same time. Set to 0 to disable.")
+	fs.StringVar(&c.maxBytesPerSec, "max-bytes-per-second", "0", "Maximum transfer rate of every response, e.g. 500K or 10M. Set to 0 to disable.")
+	fs.StringVar(&c.UnlistedArtifacts, "unlisted-artifacts", "accept", "What to do with artifacts that are not listed in the upstream index.json and can't be verified: \"accept\" (cache them and log a warning) or \"reject\".")
+	fs.BoolVar(&c.VerifySignatures, "verify-signatures", true, "Verify the minisign signature of every downloaded artifact and refuse to cache artifacts that fail verification.")
+	fs.StringVar(&c.MinisignKey, "minisign-public-key", zig.ZigPublicKey, "The minisign public key used to verify artifact signatures.")
+	fs.BoolVar(&c.Verify, "verify", false, "Verify the SHA-256 checksums and signatures of all cached artifacts against index.json, write a report to -verify-report and exit. Exits with an error if an artifact fails verification.")
+	fs.StringVar(&c.VerifyAction, "verify-action", "report", "What to do with cached artifacts that fail verification: \"report\", \"quarantine\" (move them to the quarantine directory of the cache) or \"delete\".")
+	fs.StringVar(&c.VerifyReport, "verify-report", "-", "Path of the JSON report of -verify and -scrub-interval, \"-\" writes it to stdout. The background scrub only writes reports to files.")
+	fs.IntVar(&c.ScrubInterval, "scrub-interval", 0, "Interval in seconds to verify all cached artifacts in the background, as -verify does. Set to 0 to disable.")
+	fs.StringVar(&c.importPaths, "import", "", "Comma-separated list of files and directories whose Zig artifacts (and their .minisig files) are verified, placed in the cache and exit. Directories are searched recursively, artifacts that are already cached are skipped.")
+	fs.StringVar(&c.ImportMethod, "import-method", "auto", "How -import places files in the cache: \"hardlink\", \"reflink\" (copy-on-write clone), \"copy\" or \"auto\" (the first of them that works).")
+	fs.StringVar(&c.Export, "export", "", "Write the cached artifacts selected by -versions and -platforms, their signatures, an index.json listing only them and a SHA256SUMS manifest to a bundle and exit. The bundle is a tar archive if the path ends with .tar, a directory otherwise, and can be served by another mirror as its -cache-dir.")
+	fs.StringVar(&c.scrubRate, "scrub-rate", "20M", "Maximum read rate of the background verification, e.g. 20M. Set to 0 to disable.")
 	fs.BoolVar(&c.ACME, "acme", false, "Obtain TLS certificates using the ACME challenge.")
 	fs.StringVar(&c.ACMEDirectory, "acme-directory", "https://acme-v02.api.letsencrypt.org/directory", "ACME directory URL.")
 	fs.BoolVar(&c.ACMEAcceptTOS, "acme-accept-tos", false, "Accept the ACME provider's Terms of Service.")
@@ -78,6 +230,47 @@ func ParseConfig(args []string, errorHandling flag.ErrorHandling) (Config, error
 		return c, err
 	}
 
+	if err := applySources(fs, c.configFile, lookupEnv); err != nil {
+		return c, err
+	}
+
+	if err := validateURL(c.UpstreamURL); err != nil {
+		return c, fmt.Errorf("invalid -upstream-url: %w", err)
+	}
+
+	for _, mirror := range splitList(c.upstreamMirrors) {
+		if err := validateURL(mirror); err != nil {
+			return c, fmt.Errorf("invalid -upstream-mirrors entry %q: %w", mirror, err)
+		}
+		c.UpstreamMirrors = append(c.UpstreamMirrors, mirror)
+	}
+
+	if c.UpstreamStrategy != "ordered" && c.UpstreamStrategy != "health" {
+		return c, errors.New("the -upstream-strategy flag must be either \"ordered\" or \"health\"")
+	}
+
+	if c.UpstreamCooldown <= 0 {
+		return c, errors.New("the -upstream-cooldown flag must be positive")
+	}
+
+	if c.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			return c, fmt.Errorf("invalid -metrics-address: %w", err)
+		}
+	}
+
+	for _, proxy := range splitList(c.trustedProxies) {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return c, fmt.Errorf("invalid -trusted-proxies entry %q: %w", proxy, err)
+		}
+		c.TrustedProxies = append(c.TrustedProxies, prefix)
+	}
+
+	if c.AccessLogFormat != "json" && c.AccessLogFormat != "logfmt" && c.AccessLogFormat != "combined" {
+		return c, errors.New("the -access-log-format flag must be \"json\", \"logfmt\" or \"combined\"")
+	}
+
 	if c.EnableTLS && c.ACME {
 		return c, errors.New("cannot use both -enable-tls (manual certificates) and -acme (automatic certificates) at the same time")
 	}
@@ -128,9 +321,187 @@ func ParseConfig(args []string, errorHandling flag.ErrorHandling) (Config, error
 		return c, errors.New("the -clear-builds-interval flag can't be negative")
 	}
 
+	if c.FillTimeout <= 0 {
+		return c, errors.New("the -fill-timeout flag must be positive")
+	}
+
+	if c.WriteTimeout <= 0 {
+		return c, errors.New("the -write-timeout flag must be positive")
+	}
+
+	if c.DownloadIdleTimeout <= 0 {
+		return c, errors.New("the -download-idle-timeout flag must be positive")
+	}
+
+	if c.DownloadTimeout < 0 {
+		return c, errors.New("the -download-timeout flag can't be negative")
+	}
+
+	if c.RecoveryGracePeriod < 0 {
+		return c, errors.New("the -recovery-grace-period flag can't be negative")
+	}
+
+	if c.DrainDelay < 0 {
+		return c, errors.New("the -drain-delay flag can't be negative")
+	}
+
+	if c.DrainTimeout < 0 {
+		return c, errors.New("the -drain-timeout flag can't be negative")
+	}
+
+	c.Versions = splitList(c.versions)
+	c.Platforms = splitList(c.platforms)
+
+	if c.SyncInterval < 0 {
+		return c, errors.New("the -sync-interval flag can't be negative")
+	}
+
+	if c.Offline && c.Prefetch {
+		return c, errors.New("the -prefetch flag can't be used with -offline")
+	}
+
+	if c.PrefetchJobs <= 0 {
+		return c, errors.New("the -prefetch-jobs flag must be positive")
+	}
+
+	if c.MaxFills < 0 {
+		return c, errors.New("the -max-concurrent-fills flag can't be negative")
+	}
+
+	if c.IndexTTL <= 0 {
+		return c, errors.New("the -index-ttl flag must be positive")
+	}
+
+	if c.PublicURL != "" {
+		if err := validateURL(c.PublicURL); err != nil {
+			return c, fmt.Errorf("invalid -public-url: %w", err)
+		}
+	}
+
+	if c.MinFreeSpace, err = ParseSize(c.minFreeSpace); err != nil {
+		return c, fmt.Errorf("invalid -readiness-min-free-space: %w", err)
+	}
+
+	if c.IndexMaxAge < 0 {
+		return c, errors.New("the -readiness-index-max-age flag can't be negative")
+	}
+
+	if c.MaxCacheSize, err = ParseSize(c.maxCacheSize); err != nil {
+		return c, fmt.Errorf("invalid -max-cache-size: %w", err)
+	}
+
+	if c.RateLimit < 0 {
+		return c, errors.New("the -rate-limit flag can't be negative")
+	}
+
+	if c.RateLimitBurst <= 0 {
+		return c, errors.New("the -rate-limit-burst flag must be positive")
+	}
+
+	if c.MaxClientDownloads < 0 {
+		return c, errors.New("the -max-downloads-per-client flag can't be negative")
+	}
+
+	if c.MaxBytesPerSecond, err = ParseSize(c.maxBytesPerSec); err != nil {
+		return c, fmt.Errorf("invalid -max-bytes-per-second: %w", err)
+	}
+
+	if c.EvictionPolicy != "lru" && c.EvictionPolicy != "lfu" && c.EvictionPolicy != "oldest-version" {
+		return c, errors.New("the -eviction-policy flag must be one of \"lru\", \"lfu\" or \"oldest-version\"")
+	}
+
+	if c.UnlistedArtifacts != "accept" && c.UnlistedArtifacts != "reject" {
+		return c, errors.New("the -unlisted-artifacts flag must be either \"accept\" or \"reject\"")
+	}
+
+	if c.VerifyAction != "report" && c.VerifyAction != "quarantine" && c.VerifyAction != "delete" {
+		return c, errors.New("the -verify-action flag must be \"report\", \"quarantine\" or \"delete\"")
+	}
+
+	c.Import = splitList(c.importPaths)
+
+	if c.ImportMethod != "auto" && c.ImportMethod != "hardlink" && c.ImportMethod != "reflink" && c.ImportMethod != "copy" {
+		return c, errors.New("the -import-method flag must be \"auto\", \"hardlink\", \"reflink\" or \"copy\"")
+	}
+
+	if c.ScrubInterval < 0 {
+		return c, errors.New("the -scrub-interval flag can't be negative")
+	}
+
+	if c.ScrubRate, err = ParseSize(c.scrubRate); err != nil {
+		return c, fmt.Errorf("invalid -scrub-rate: %w", err)
+	}
+
+	if c.VerifySignatures {
+		publicKey, err := zig.ParsePublicKey(c.MinisignKey)
+		if err != nil {
+			return c, fmt.Errorf("failed to parse -minisign-public-key: %w", err)
+		}
+		c.PublicKey = publicKey
+	}
+
+	c.effective = effectiveValues(fs)
+
 	return c, nil
 }
 
+// ParseSize parses a size in bytes with an optional binary unit suffix, e.g. 1024, 500M, 50G or 2TiB.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	if s != "" {
+		if i := strings.IndexByte("KMGTP", s[len(s)-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.New("size can't be negative")
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, errors.New("size is too large")
+	}
+
+	return n * multiplier, nil
+}
+
+// Splits a comma-separated list, ignoring empty entries.
+func splitList(s string) []string {
+	var list []string
+	for entry := range strings.SplitSeq(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// Checks that a URL is an absolute http(s) URL.
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// IndexURLs returns the URLs index.json is fetched from.
+// Community mirrors only serve the artifacts and their signatures in a flat layout without index.json,
+// so only the canonical upstream is used, the stored copy covers its outages.
+func (c Config) IndexURLs() []string {
+	return []string{strings.TrimSuffix(c.UpstreamURL, "/") + "/download/index.json"}
+}
+
 // HTTPAddress returns the full address for the HTTP server.
 func (c Config) HTTPAddress() string {
 	return net.JoinHostPort(c.ListenAddress, strconv.Itoa(c.HTTPPort))
@@ -149,3 +520,17 @@ func (c *Config) AcceptTOS(tosURL string) bool {
 	}
 	return c.ACMEAcceptTOS
 }
+
+// Parses a CIDR range or a single IP address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"slices"
 	"testing"
 )
 
@@ -81,6 +82,61 @@ func TestHTTPSAddress(t *testing.T) {
 
 }
 
+func TestIndexURLs(t *testing.T) {
+	t.Parallel()
+
+	c := Config{
+		UpstreamURL:     "https://ziglang.org/",
+		UpstreamMirrors: []string{"https://mirror.example.com"},
+	}
+
+	// Mirrors don't serve index.json.
+	expected := []string{
+		"https://ziglang.org/download/index.json",
+	}
+
+	if got := c.IndexURLs(); !slices.Equal(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in          string
+		expected    int64
+		expectError bool
+	}{
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"1K", 1 << 10, false},
+		{"500M", 500 << 20, false},
+		{"50G", 50 << 30, false},
+		{"50g", 50 << 30, false},
+		{"2TiB", 2 << 40, false},
+		{"2 TB", 2 << 40, false},
+		{"", 0, true},
+		{"G", 0, true},
+		{"-1G", 0, true},
+		{"1.5G", 0, true},
+		{"99999999999P", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseSize(tt.in)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("got error %v, want error %v", err, tt.expectError)
+			}
+			if got != tt.expected {
+				t.Errorf("got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestAcceptTOS(t *testing.T) {
 	t.Parallel()
 
@@ -159,6 +215,65 @@ func TestParseConfig(t *testing.T) {
 		}, false},
 		{"Negative clear builds interval", []string{"-clear-builds-interval", "-5"}, true},
 		{"Zero clear builds interval (valid)", []string{"-clear-builds-interval", "0"}, false},
+		{"Upstream mirrors", []string{"-upstream-mirrors", "https://mirror.example.com, https://zig.example.org/mirror/"}, false},
+		{"Invalid upstream mirror", []string{"-upstream-mirrors", "https://mirror.example.com,mirror.example.org"}, true},
+		{"Invalid upstream URL", []string{"-upstream-url", "ziglang.org"}, true},
+		{"Health upstream strategy", []string{"-upstream-strategy", "health"}, false},
+		{"Unknown upstream strategy", []string{"-upstream-strategy", "random"}, true},
+		{"Zero upstream cooldown", []string{"-upstream-cooldown", "0"}, true},
+		{"Max cache size", []string{"-max-cache-size", "500G"}, false},
+		{"Invalid max cache size", []string{"-max-cache-size", "lots"}, true},
+		{"LFU eviction policy", []string{"-eviction-policy", "lfu"}, false},
+		{"Unknown eviction policy", []string{"-eviction-policy", "random"}, true},
+		{"Zero fill timeout", []string{"-fill-timeout", "0"}, true},
+		{"Download timeouts", []string{"-write-timeout", "5", "-download-idle-timeout", "30", "-download-timeout", "3600"}, false},
+		{"Zero write timeout", []string{"-write-timeout", "0"}, true},
+		{"Zero download idle timeout", []string{"-download-idle-timeout", "0"}, true},
+		{"Negative download timeout", []string{"-download-timeout", "-1"}, true},
+		{"Recovery", []string{"-recovery-grace-period", "3600", "-recovery-fix"}, false},
+		{"Negative recovery grace period", []string{"-recovery-grace-period", "-1"}, true},
+		{"Zero drain timeout", []string{"-drain-timeout", "0"}, false},
+		{"Negative drain timeout", []string{"-drain-timeout", "-1"}, true},
+		{"Drain delay", []string{"-drain-delay", "10"}, false},
+		{"Negative drain delay", []string{"-drain-delay", "-1"}, true},
+		{"Prefetch", []string{"-prefetch", "-versions", "0.14.1,master", "-platforms", "x86_64-linux", "-prefetch-jobs", "8"}, false},
+		{"Offline", []string{"-offline", "-upstream-url", "http://127.0.0.1:1"}, false},
+		{"Prefetch while offline", []string{"-offline", "-prefetch"}, true},
+		{"Sync interval", []string{"-sync-interval", "600", "-platforms", "x86_64-linux"}, false},
+		{"Negative sync interval", []string{"-sync-interval", "-1"}, true},
+		{"Zero prefetch jobs", []string{"-prefetch-jobs", "0"}, true},
+		{"Metrics address", []string{"-metrics-address", "127.0.0.1:9100"}, false},
+		{"Metrics address without a port", []string{"-metrics-address", "127.0.0.1"}, true},
+		{"Access log", []string{"-access-log", "-", "-access-log-format", "combined"}, false},
+		{"Invalid access log format", []string{"-access-log-format", "xml"}, true},
+		{"Trusted proxies", []string{"-trusted-proxies", "127.0.0.1, 10.0.0.0/8,::1"}, false},
+		{"Invalid trusted proxy", []string{"-trusted-proxies", "10.0.0.0/33"}, true},
+		{"Rate limits", []string{"-rate-limit", "2.5", "-rate-limit-burst", "10", "-max-downloads-per-client", "4", "-max-bytes-per-second", "10M"}, false},
+		{"Concurrent fills", []string{"-max-concurrent-fills", "2"}, false},
+		{"Negative concurrent fills", []string{"-max-concurrent-fills", "-1"}, true},
+		{"Negative rate limit", []string{"-rate-limit", "-1"}, true},
+		{"Zero rate limit burst", []string{"-rate-limit-burst", "0"}, true},
+		{"Negative downloads per client", []string{"-max-downloads-per-client", "-1"}, true},
+		{"Invalid bytes per second", []string{"-max-bytes-per-second", "fast"}, true},
+		{"Readiness thresholds", []string{"-readiness-min-free-space", "10G", "-readiness-index-max-age", "3600"}, false},
+		{"Invalid readiness free space", []string{"-readiness-min-free-space", "-1G"}, true},
+		{"Negative readiness index max age", []string{"-readiness-index-max-age", "-1"}, true},
+		{"Zero index TTL", []string{"-index-ttl", "0"}, true},
+		{"Public URL", []string{"-public-url", "https://zig.example.com/"}, false},
+		{"Invalid public URL", []string{"-public-url", "zig.example.com"}, true},
+		{"Reject unlisted artifacts", []string{"-unlisted-artifacts", "reject"}, false},
+		{"Unknown unlisted artifacts policy", []string{"-unlisted-artifacts", "maybe"}, true},
+		{"Custom minisign public key", []string{"-minisign-public-key", "RWSGOq2NVecA2UPNdBUZykf1CCb147pkmdtYxgb3Ti+JO/wCYvhbAb/U"}, false},
+		{"Invalid minisign public key", []string{"-minisign-public-key", "not-a-key"}, true},
+		{"Invalid key with verification disabled", []string{"-verify-signatures=false", "-minisign-public-key", "not-a-key"}, false},
+		{"Verify", []string{"-verify", "-verify-action", "quarantine", "-verify-report", "report.json"}, false},
+		{"Unknown verify action", []string{"-verify-action", "repair"}, true},
+		{"Background scrub", []string{"-scrub-interval", "86400", "-scrub-rate", "50M"}, false},
+		{"Negative scrub interval", []string{"-scrub-interval", "-1"}, true},
+		{"Invalid scrub rate", []string{"-scrub-rate", "slow"}, true},
+		{"Import", []string{"-import", "/mnt/zig,/media/usb/zig-0.14.1.tar.xz", "-import-method", "copy"}, false},
+		{"Export", []string{"-export", "/media/usb/zig-bundle.tar", "-versions", "0.14.1", "-platforms", "x86_64-linux,x86_64-windows"}, false},
+		{"Unknown import method", []string{"-import-method", "symlink"}, true},
 		{"Invalid flag name", []string{"-not-a-flag", "value"}, true},
 		{"Invalid port type", []string{"-http-port", "not-a-number"}, true},
 	}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Prefix of the environment variables, e.g. ZIGMIRROR_CACHE_DIR sets -cache-dir.
+const envPrefix = "ZIGMIRROR_"
+
+// Flags that select the configuration sources or run a one-shot task instead of the server,
+// they are not part of the configuration itself and can only be set on the command line.
+var metaFlags = []string{"config", "print-config", "version", "show-possible-size", "prefetch", "import", "export", "verify"}
+
+// Returns the name of the environment variable of a flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Applies the config file and the environment variables to the flags that were not set on the command line.
+// Environment variables take precedence over the config file.
+func applySources(fs *flag.FlagSet, configFile string, lookupEnv func(string) (string, bool)) error {
+	setOnCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	if configFile == "" {
+		configFile, _ = lookupEnv(envName("config"))
+	}
+
+	if configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(values)) {
+			value := values[name]
+			if fs.Lookup(name) == nil || slices.Contains(metaFlags, name) {
+				return fmt.Errorf("unknown option %q in config file %s", name, configFile)
+			}
+			if setOnCommandLine[name] {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for %q in config file %s: %w", value, name, configFile, err)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || setOnCommandLine[f.Name] || slices.Contains(metaFlags, f.Name) {
+			return
+		}
+		if value, ok := lookupEnv(envName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), setErr)
+			}
+		}
+	})
+	return err
+}
+
+// Reads a JSON config file, an object with the flag names as keys.
+// Values can be strings, numbers, booleans, or arrays of strings for comma-separated lists.
+func readConfigFile(name string) (map[string]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the config file: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse the config file %s: %w", name, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		case bool:
+			values[key] = fmt.Sprint(v)
+		case []any:
+			var list []string
+			for _, entry := range v {
+				s, ok := entry.(string)
+				if !ok {
+					return nil, fmt.Errorf("the list %q in the config file %s must only contain strings", key, name)
+				}
+				list = append(list, s)
+			}
+			values[key] = strings.Join(list, ",")
+		default:
+			return nil, fmt.Errorf("unsupported value for %q in the config file %s", key, name)
+		}
+	}
+	return values, nil
+}
+
+// Returns the effective value of every flag, with credentials in URLs redacted.
+func effectiveValues(fs *flag.FlagSet) map[string]any {
+	values := make(map[string]any)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(metaFlags, f.Name) {
+			return
+		}
+
+		var value any = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+		if s, ok := value.(string); ok {
+			value = redactURLs(s)
+		}
+		values[f.Name] = value
+	})
+
+	return values
+}
+
+// Redacts the credentials of the URLs in a (comma-separated list of) value(s).
+func redactURLs(s string) string {
+	entries := strings.Split(s, ",")
+	for i, entry := range entries {
+		if u, err := url.Parse(strings.TrimSpace(entry)); err == nil && u.User != nil {
+			u.User = url.User("REDACTED")
+			entries[i] = u.String()
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// EffectiveConfig returns the merged configuration of all sources as a JSON config file.
+// Credentials in URLs are redacted.
+func (c Config) EffectiveConfig() ([]byte, error) {
+	data, err := json.MarshalIndent(c.effective, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ChangedSettings returns the names of the flags whose effective values differ in other, sorted by name.
+func (c Config) ChangedSettings(other Config) []string {
+	var changed []string
+	for _, name := range slices.Sorted(maps.Keys(other.effective)) {
+		if value, ok := c.effective[name]; !ok || value != other.effective[name] {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
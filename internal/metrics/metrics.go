@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/savalione/go-mirror-zig/internal/zig"
+)
+
+// Upper bounds in seconds of upstream downloads, from a signature to a slow tarball.
+var fillDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// Upper bounds in seconds of waiting for the lock of the in-flight downloads.
+var lockWaitBuckets = []float64{0.00001, 0.0001, 0.001, 0.01, 0.1, 1}
+
+// Metrics of the mirror.
+type Metrics struct {
+	Registry *Registry
+
+	// Requests for artifacts by result (hit, miss or coalesced), channel (stable or dev), platform and version.
+	CacheRequests *Counter
+	// Bytes sent to clients by where they came from (cache or fill).
+	ServedBytes *Counter
+	// Duration of upstream downloads by result (success or failure).
+	FillDuration *Histogram
+	// Bytes downloaded from upstreams by upstream.
+	FillBytes *Counter
+	// Upstream responses by upstream and status code.
+	UpstreamResponses *Counter
+	// Time spent waiting for the lock of the in-flight downloads.
+	FillLockWait *Histogram
+	// Cleanup runs and reclaimed bytes by kind (dev-builds or eviction).
+	CleanupRuns           *Counter
+	CleanupReclaimedBytes *Counter
+	// Requests rejected by the rate limiter by limit (requests or downloads).
+	RateLimited *Counter
+	// Cached artifacts checked by the cache scrub by status (ok, corrupt, unverified or error).
+	ScrubbedFiles *Counter
+}
+
+// Creates all metrics of the mirror in a new registry.
+func New() *Metrics {
+	r := NewRegistry()
+
+	return &Metrics{
+		Registry:              r,
+		CacheRequests:         r.Counter("zigmirror_cache_requests_total", "Requests for artifacts by cache result.", "result", "channel", "platform", "version"),
+		ServedBytes:           r.Counter("zigmirror_served_bytes_total", "Bytes of artifacts sent to clients.", "source"),
+		FillDuration:          r.Histogram("zigmirror_upstream_fill_duration_seconds", "Duration of upstream downloads.", fillDurationBuckets, "result"),
+		FillBytes:             r.Counter("zigmirror_upstream_fill_bytes_total", "Bytes downloaded from upstreams.", "upstream"),
+		UpstreamResponses:     r.Counter("zigmirror_upstream_responses_total", "Upstream responses by status code.", "upstream", "code"),
+		FillLockWait:          r.Histogram("zigmirror_fill_lock_wait_seconds", "Time spent waiting for the lock of the in-flight downloads.", lockWaitBuckets),
+		CleanupRuns:           r.Counter("zigmirror_cleanup_runs_total", "Cache cleanup runs.", "kind"),
+		CleanupReclaimedBytes: r.Counter("zigmirror_cleanup_reclaimed_bytes_total", "Bytes reclaimed by cache cleanups.", "kind"),
+		RateLimited:           r.Counter("zigmirror_rate_limited_requests_total", "Requests rejected by the per-client rate limits.", "limit"),
+		ScrubbedFiles:         r.Counter("zigmirror_scrub_files_total", "Cached artifacts checked by the cache scrub.", "status"),
+	}
+}
+
+// Returns the channel (stable or dev), platform and version labels of an artifact.
+// All dev builds share the "master" version, so that every new build doesn't add new series.
+func ArtifactLabels(filename string) (channel, platform, version string) {
+	filename = strings.TrimSuffix(filename, ".minisig")
+
+	submatches := zig.ArtifactSubmatches(filename)
+	if submatches == nil {
+		return "unknown", "unknown", "unknown"
+	}
+
+	platform = zig.ArtifactPlatform(filename)
+	version = submatches[1]
+
+	if strings.Contains(version, "-dev") {
+		return "dev", platform, "master"
+	}
+	return "stable", platform, version
+}
@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Separates label values in the keys of samples.
+const labelSeparator = "\xff"
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and exposes them in the Prometheus text format.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	bw.Flush()
+}
+
+// Counter is a monotonically increasing value partitioned by labels.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+// Counter registers a new counter with the provided label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		samples: make(map[string]*sample),
+	}
+	r.register(c)
+	return c
+}
+
+// Add increases the counter of the provided label values (in the order of the label names) by v.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, labelSeparator)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.samples[key]
+	if !ok {
+		s = &sample{labelValues: slices.Clone(labelValues)}
+		c.samples[key] = s
+	}
+	s.value += v
+}
+
+// Inc increases the counter of the provided label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current value of the counter with the provided label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.samples[strings.Join(labelValues, labelSeparator)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.samples) {
+		s := c.samples[key]
+		writeSample(w, c.name, c.labels, s.labelValues, "", s.value)
+	}
+}
+
+// Histogram counts observations (e.g. durations) in buckets partitioned by labels.
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu      sync.Mutex
+	samples map[string]*histogramSample
+}
+
+type histogramSample struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// Histogram registers a new histogram with the provided upper bounds of the buckets and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: slices.Sorted(slices.Values(buckets)),
+		samples: make(map[string]*histogramSample),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds a single observation to the histogram of the provided label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, labelSeparator)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.samples[key]
+	if !ok {
+		s = &histogramSample{labelValues: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.samples[key] = s
+	}
+
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.samples) {
+		s := h.samples[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.labelValues, formatValue(bound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.labelValues, "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.labelValues, "", s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.labelValues, "", float64(s.count))
+	}
+}
+
+// gaugeFunc is a value that is computed on every scrape.
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// GaugeFunc registers a gauge whose value is returned by fn on every scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	writeSample(w, g.name, nil, nil, "", g.fn())
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Writes a single sample line, le is the bucket label of histograms (empty for other metrics).
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, le string, value float64) {
+	w.WriteString(name)
+
+	if len(labels) > 0 || le != "" {
+		escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			var labelValue string
+			if i < len(labelValues) {
+				labelValue = labelValues[i]
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, escape.Replace(labelValue))
+		}
+		if le != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `le="%s"`, le)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+
+	requests := r.Counter("test_requests_total", "Requests.", "result", "path")
+	requests.Inc("hit", "/a")
+	requests.Inc("hit", "/a")
+	requests.Add(3, "miss", `/"b"`)
+
+	duration := r.Histogram("test_duration_seconds", "Duration.", []float64{1, 0.1}, "result")
+	duration.Observe(0.05, "ok")
+	duration.Observe(0.1, "ok")
+	duration.Observe(5, "ok")
+
+	r.GaugeFunc("test_size_bytes", "Size.", func() float64 { return 1024 })
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{result="hit",path="/a"} 2
+test_requests_total{result="miss",path="/\"b\""} 3
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{result="ok",le="0.1"} 2
+test_duration_seconds_bucket{result="ok",le="1"} 2
+test_duration_seconds_bucket{result="ok",le="+Inf"} 3
+test_duration_seconds_sum{result="ok"} 5.15
+test_duration_seconds_count{result="ok"} 3
+# HELP test_size_bytes Size.
+# TYPE test_size_bytes gauge
+test_size_bytes 1024
+`
+
+	if got := rr.Body.String(); got != expected {
+		t.Errorf("got\n%s\nwant\n%s", got, expected)
+	}
+
+	if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %v, want the Prometheus text format", got)
+	}
+
+	if got := requests.Value("hit", "/a"); got != 2 {
+		t.Errorf("got counter value %v, want 2", got)
+	}
+}
+
+func TestArtifactLabels(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in       string
+		expected [3]string
+	}{
+		{"zig-x86_64-linux-0.14.1.tar.xz", [3]string{"stable", "x86_64-linux", "0.14.1"}},
+		{"zig-x86_64-linux-0.14.1.tar.xz.minisig", [3]string{"stable", "x86_64-linux", "0.14.1"}},
+		{"zig-0.14.1.tar.xz", [3]string{"stable", "src", "0.14.1"}},
+		{"zig-aarch64-macos-0.17.0-dev.305+bdfbf432d.tar.xz", [3]string{"dev", "aarch64-macos", "master"}},
+		{"index.json", [3]string{"unknown", "unknown", "unknown"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			channel, platform, version := ArtifactLabels(tt.in)
+			if got := [3]string{channel, platform, version}; got != tt.expected {
+				t.Errorf("got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -11,6 +11,16 @@ import (
 // Fetch the file with all Zig releases
 // Such file is usually located here: https://ziglang.org/download/index.json
 func FetchAllReleases(ctx context.Context, url string) (ZigReleases, error) {
+	body, err := FetchIndex(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseReleases(body)
+}
+
+// Fetch the raw contents of the file with all Zig releases
+func FetchIndex(ctx context.Context, url string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
@@ -26,13 +36,13 @@ func FetchAllReleases(ctx context.Context, url string) (ZigReleases, error) {
 		return nil, fmt.Errorf("bad status: %s", resp.Status)
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+	return io.ReadAll(resp.Body)
+}
 
+// Parse the contents of the file with all Zig releases
+func ParseReleases(data []byte) (ZigReleases, error) {
 	var zr ZigReleases
-	if err := json.Unmarshal(body, &zr); err != nil {
+	if err := json.Unmarshal(data, &zr); err != nil {
 		return nil, err
 	}
 
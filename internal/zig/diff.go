@@ -0,0 +1,75 @@
+package zig
+
+import (
+	"path"
+	"slices"
+)
+
+// Changes between two versions of index.json.
+type ReleaseChanges struct {
+	// NewVersions are the versions of releases that were published since the previous index.json,
+	// including a new master build.
+	NewVersions []string
+	// AddedArtifacts, ChangedArtifacts and RemovedArtifacts are artifact filenames (e.g. zig-x86_64-linux-0.14.1.tar.xz).
+	// An artifact changed if its checksum or size is different.
+	AddedArtifacts   []string
+	ChangedArtifacts []string
+	RemovedArtifacts []string
+}
+
+// Reports whether anything changed.
+func (rc ReleaseChanges) Empty() bool {
+	return len(rc.NewVersions) == 0 && len(rc.AddedArtifacts) == 0 && len(rc.ChangedArtifacts) == 0 && len(rc.RemovedArtifacts) == 0
+}
+
+// Returns what changed since the previous index.json. All lists are sorted.
+func (zr ZigReleases) Diff(previous ZigReleases) ReleaseChanges {
+	var rc ReleaseChanges
+
+	for name, release := range zr {
+		old, ok := previous[name]
+		if !ok || old.Version != release.Version {
+			version := release.Version
+			if version == "" {
+				version = name
+			}
+			rc.NewVersions = append(rc.NewVersions, version)
+		}
+	}
+
+	oldArtifacts := previous.artifactsByFilename()
+	newArtifacts := zr.artifactsByFilename()
+
+	for filename, artifact := range newArtifacts {
+		old, ok := oldArtifacts[filename]
+		switch {
+		case !ok:
+			rc.AddedArtifacts = append(rc.AddedArtifacts, filename)
+		case old.Shasum != artifact.Shasum || old.Size != artifact.Size:
+			rc.ChangedArtifacts = append(rc.ChangedArtifacts, filename)
+		}
+	}
+
+	for filename := range oldArtifacts {
+		if _, ok := newArtifacts[filename]; !ok {
+			rc.RemovedArtifacts = append(rc.RemovedArtifacts, filename)
+		}
+	}
+
+	slices.Sort(rc.NewVersions)
+	slices.Sort(rc.AddedArtifacts)
+	slices.Sort(rc.ChangedArtifacts)
+	slices.Sort(rc.RemovedArtifacts)
+
+	return rc
+}
+
+func (zr ZigReleases) artifactsByFilename() map[string]Artifact {
+	artifacts := make(map[string]Artifact)
+	for _, release := range zr {
+		for _, artifact := range release.Platforms {
+			artifacts[path.Base(artifact.Tarball)] = artifact
+		}
+	}
+	return artifacts
+}
@@ -0,0 +1,86 @@
+package zig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReleasesDiff(t *testing.T) {
+	t.Parallel()
+
+	previous := ZigReleases{
+		"master": Release{
+			Version: "0.17.0-dev.305+bdfbf432d",
+			Platforms: map[string]Artifact{
+				"x86_64-linux": {Tarball: "https://ziglang.org/builds/zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz", Shasum: "a"},
+			},
+		},
+		"0.14.1": Release{
+			Version: "0.14.1",
+			Platforms: map[string]Artifact{
+				"x86_64-linux":  {Tarball: "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz", Shasum: "b"},
+				"aarch64-macos": {Tarball: "https://ziglang.org/download/0.14.1/zig-aarch64-macos-0.14.1.tar.xz", Shasum: "c"},
+			},
+		},
+	}
+
+	current := ZigReleases{
+		"master": Release{
+			Version: "0.17.0-dev.400+ccccccccc",
+			Platforms: map[string]Artifact{
+				"x86_64-linux": {Tarball: "https://ziglang.org/builds/zig-x86_64-linux-0.17.0-dev.400+ccccccccc.tar.xz", Shasum: "d"},
+			},
+		},
+		"0.15.0": Release{
+			Version: "0.15.0",
+			Platforms: map[string]Artifact{
+				"x86_64-linux": {Tarball: "https://ziglang.org/download/0.15.0/zig-x86_64-linux-0.15.0.tar.xz", Shasum: "e"},
+			},
+		},
+		"0.14.1": Release{
+			Version: "0.14.1",
+			Platforms: map[string]Artifact{
+				"x86_64-linux": {Tarball: "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz", Shasum: "f"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		current  ZigReleases
+		previous ZigReleases
+		expected ReleaseChanges
+	}{
+		{
+			name:     "nothing changed",
+			current:  previous,
+			previous: previous,
+			expected: ReleaseChanges{},
+		},
+		{
+			name:     "new release, new master build, changed and removed artifacts",
+			current:  current,
+			previous: previous,
+			expected: ReleaseChanges{
+				NewVersions:      []string{"0.15.0", "0.17.0-dev.400+ccccccccc"},
+				AddedArtifacts:   []string{"zig-x86_64-linux-0.15.0.tar.xz", "zig-x86_64-linux-0.17.0-dev.400+ccccccccc.tar.xz"},
+				ChangedArtifacts: []string{"zig-x86_64-linux-0.14.1.tar.xz"},
+				RemovedArtifacts: []string{"zig-aarch64-macos-0.14.1.tar.xz", "zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := tt.current.Diff(tt.previous)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got %+v, want %+v", got, tt.expected)
+			}
+			if got.Empty() != tt.expected.Empty() {
+				t.Errorf("got empty %v, want %v", got.Empty(), tt.expected.Empty())
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package zig
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/savalione/go-mirror-zig/internal/atomicfile"
+)
+
+// Minimum age of the cached index.json before a lookup miss triggers a refetch.
+// A fresh master build is usually published between two refreshes.
+const indexMissRefresh = time.Minute
+
+// Maximum duration of a fetch of index.json.
+// Fetches run detached from the callers, which only wait for them as long as their context allows.
+const indexRefreshTimeout = 30 * time.Second
+
+// Index keeps the most recently fetched index.json in memory
+// and refreshes it from upstream once it is older than the TTL.
+type Index struct {
+	urls      []string
+	ttl       time.Duration
+	cacheFile string
+
+	mu         sync.Mutex
+	raw        []byte
+	releases   ZigReleases
+	fetchedAt  time.Time
+	loaded     bool          // whether the cache file was read
+	refreshed  chan struct{} // closed when the running refresh ends, nil if none is running
+	refreshErr error         // result of the last refresh
+
+	// The cached copy, readable without the lock, nil if there is no copy.
+	snapshot atomic.Pointer[indexSnapshot]
+}
+
+type indexSnapshot struct {
+	releases  ZigReleases
+	fetchedAt time.Time
+}
+
+// Creates an index backed by the index.json located at the provided URLs.
+// The URLs are tried in order, the canonical upstream should come first.
+// Every fetched index.json is also stored in cacheFile (unless it is empty),
+// so that it survives a restart of the server and outages of the upstream.
+func NewIndex(urls []string, ttl time.Duration, cacheFile string) *Index {
+	return &Index{
+		urls:      urls,
+		ttl:       ttl,
+		cacheFile: cacheFile,
+	}
+}
+
+// Returns all releases from the cached index.json, fetching it first if the cached copy is missing or expired.
+// A stale copy is returned if the refresh fails, an error is returned only if there is nothing to fall back to.
+func (i *Index) Releases(ctx context.Context) (ZigReleases, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.loadLocked()
+
+	if i.releases == nil {
+		err := i.waitLocked(ctx, i.refreshLocked())
+		if i.releases == nil {
+			return nil, err
+		}
+		return i.releases, nil
+	}
+
+	if time.Since(i.fetchedAt) >= i.ttl {
+		i.waitLocked(ctx, i.refreshLocked())
+	}
+
+	return i.releases, nil
+}
+
+// Returns the contents of the cached index.json and the time it was fetched.
+// An expired copy is returned right away and refreshed in the background (stale-while-revalidate),
+// the caller only waits for upstream if there is no copy at all.
+func (i *Index) Raw(ctx context.Context) ([]byte, time.Time, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.loadLocked()
+
+	if i.raw == nil {
+		err := i.waitLocked(ctx, i.refreshLocked())
+		if i.raw == nil {
+			return nil, time.Time{}, err
+		}
+		return i.raw, i.fetchedAt, nil
+	}
+
+	if time.Since(i.fetchedAt) >= i.ttl {
+		i.refreshLocked()
+	}
+
+	return i.raw, i.fetchedAt, nil
+}
+
+// Returns the time the cached copy was fetched without waiting for the lock or for upstream, ok is false if there is no copy yet.
+// Like Raw, it starts a refresh of a missing or expired copy, but it never waits for it.
+func (i *Index) FetchedAt() (fetchedAt time.Time, ok bool) {
+	if snapshot := i.peek(); snapshot != nil {
+		return snapshot.fetchedAt, true
+	}
+	return time.Time{}, false
+}
+
+// Returns the releases of the cached copy without waiting for the lock or for upstream, nil if there is no copy yet.
+// Like Raw, it starts a refresh of a missing or expired copy, but it never waits for it.
+func (i *Index) Cached() ZigReleases {
+	if snapshot := i.peek(); snapshot != nil {
+		return snapshot.releases
+	}
+	return nil
+}
+
+func (i *Index) peek() *indexSnapshot {
+	if i.mu.TryLock() {
+		i.loadLocked()
+		if i.raw == nil || time.Since(i.fetchedAt) >= i.ttl {
+			i.refreshLocked()
+		}
+		i.mu.Unlock()
+	}
+	return i.snapshot.Load()
+}
+
+// Looks up an artifact by its filename (e.g. zig-x86_64-linux-0.14.1.tar.xz).
+// If the artifact is not listed, index.json is refetched once in case a new build was published in the meantime.
+func (i *Index) Lookup(ctx context.Context, filename string) (Artifact, bool, error) {
+	zr, err := i.Releases(ctx)
+	if err != nil {
+		return Artifact{}, false, err
+	}
+
+	if artifact, ok := zr.FindArtifact(filename); ok {
+		return artifact, true, nil
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if time.Since(i.fetchedAt) < indexMissRefresh {
+		return Artifact{}, false, nil
+	}
+
+	// Releases returned a copy, so there is one to fall back to.
+	i.waitLocked(ctx, i.refreshLocked())
+
+	artifact, ok := i.releases.FindArtifact(filename)
+	return artifact, ok, nil
+}
+
+// Fetches index.json regardless of the age of the cached copy and returns all releases from it.
+// Unlike the other methods, it fails if upstream can't be reached even if there is a cached copy.
+func (i *Index) Refresh(ctx context.Context) (ZigReleases, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.loadLocked()
+
+	if err := i.waitLocked(ctx, i.refreshLocked()); err != nil {
+		return nil, err
+	}
+
+	return i.releases, nil
+}
+
+// Starts a refresh of index.json, or joins the one that is already running, and returns a channel closed when it ends.
+// The fetch runs without the lock and with its own timeout, so that the other callers neither wait for the lock
+// nor keep a fetch running for as long as their own context allows.
+// The cached copy is kept if the fetch fails.
+func (i *Index) refreshLocked() <-chan struct{} {
+	if i.refreshed != nil {
+		return i.refreshed
+	}
+
+	done := make(chan struct{})
+	i.refreshed = done
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), indexRefreshTimeout)
+		defer cancel()
+
+		raw, zr, err := i.fetch(ctx)
+
+		i.mu.Lock()
+		defer i.mu.Unlock()
+
+		if err == nil {
+			i.setLocked(raw, zr)
+		}
+		i.refreshErr = err
+		i.refreshed = nil
+		close(done)
+	}()
+
+	return done
+}
+
+// Waits for a refresh started by refreshLocked and returns its error.
+// The lock is released while waiting, the context only bounds the wait and not the refresh itself.
+func (i *Index) waitLocked(ctx context.Context, done <-chan struct{}) error {
+	i.mu.Unlock()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	i.mu.Lock()
+
+	select {
+	case <-done:
+		return i.refreshErr
+	default:
+		return ctx.Err()
+	}
+}
+
+// Fetches index.json from the first URL that works, it doesn't touch the cached copy.
+func (i *Index) fetch(ctx context.Context) ([]byte, ZigReleases, error) {
+	var raw []byte
+	var zr ZigReleases
+	err := errors.New("no index.json URL configured")
+	for _, url := range i.urls {
+		if raw, err = FetchIndex(ctx, url); err != nil {
+			continue
+		}
+		if zr, err = ParseReleases(raw); err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return raw, zr, nil
+}
+
+// Replaces the cached copy with a fetched index.json.
+func (i *Index) setLocked(raw []byte, zr ZigReleases) {
+	i.raw = raw
+	i.releases = zr
+	i.fetchedAt = time.Now()
+	i.snapshot.Store(&indexSnapshot{releases: i.releases, fetchedAt: i.fetchedAt})
+
+	i.storeLocked()
+}
+
+// Reads the index.json stored by a previous run of the server.
+// Its modification time is the time it was fetched, so an old copy is refreshed as usual.
+func (i *Index) loadLocked() {
+	if i.loaded || i.cacheFile == "" {
+		return
+	}
+	i.loaded = true
+
+	info, err := os.Stat(i.cacheFile)
+	if err != nil {
+		return
+	}
+
+	raw, err := os.ReadFile(i.cacheFile)
+	if err != nil {
+		return
+	}
+
+	zr, err := ParseReleases(raw)
+	if err != nil {
+		return
+	}
+
+	i.raw = raw
+	i.releases = zr
+	i.fetchedAt = info.ModTime()
+	i.snapshot.Store(&indexSnapshot{releases: i.releases, fetchedAt: i.fetchedAt})
+}
+
+// Stores the cached index.json on disk.
+// It is best effort, the in-memory copy is used either way.
+func (i *Index) storeLocked() {
+	if i.cacheFile == "" {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(i.cacheFile), 0775); err != nil {
+		return
+	}
+
+	atomicfile.WriteFile(i.cacheFile, i.raw)
+}
+
+// Finds the artifact whose tarball URL ends with the provided filename.
+func (zr ZigReleases) FindArtifact(filename string) (Artifact, bool) {
+	for _, release := range zr {
+		for _, artifact := range release.Platforms {
+			if path.Base(artifact.Tarball) == filename {
+				return artifact, true
+			}
+		}
+	}
+	return Artifact{}, false
+}
+
+// Returns the artifacts of the provided releases (e.g. 0.14.1 or master) and platforms (e.g. x86_64-linux or src), sorted by their tarball URL.
+// A release matches either its key in index.json or its version. Empty lists select everything.
+func (zr ZigReleases) Select(versions, platforms []string) []Artifact {
+	var artifacts []Artifact
+	for name, release := range zr {
+		if len(versions) > 0 && !slices.Contains(versions, name) && !slices.Contains(versions, release.Version) {
+			continue
+		}
+		for platform, artifact := range release.Platforms {
+			if len(platforms) > 0 && !slices.Contains(platforms, platform) {
+				continue
+			}
+			artifacts = append(artifacts, artifact)
+		}
+	}
+
+	slices.SortFunc(artifacts, func(a, b Artifact) int {
+		return strings.Compare(a.Tarball, b.Tarball)
+	})
+
+	return artifacts
+}
@@ -0,0 +1,324 @@
+package zig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFindArtifact(t *testing.T) {
+	t.Parallel()
+
+	zr := ZigReleases{
+		"0.14.1": Release{
+			Platforms: map[string]Artifact{
+				"x86_64-linux": {Tarball: "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz", Shasum: "abc"},
+			},
+		},
+		"master": Release{
+			Platforms: map[string]Artifact{
+				"src": {Tarball: "https://ziglang.org/builds/zig-0.17.0-dev.305+bdfbf432d.tar.xz", Shasum: "def"},
+			},
+		},
+	}
+
+	tests := []struct {
+		in       string
+		expected string
+		found    bool
+	}{
+		{"zig-x86_64-linux-0.14.1.tar.xz", "abc", true},
+		{"zig-0.17.0-dev.305+bdfbf432d.tar.xz", "def", true},
+		{"zig-aarch64-linux-0.14.1.tar.xz", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			artifact, found := zr.FindArtifact(tt.in)
+			if found != tt.found || artifact.Shasum != tt.expected {
+				t.Errorf("got (%q, %v), want (%q, %v)", artifact.Shasum, found, tt.expected, tt.found)
+			}
+		})
+	}
+}
+
+func TestIndexLookup(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`{
+			"0.14.1": {
+				"version": "0.14.1",
+				"x86_64-linux": {
+					"tarball": "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz",
+					"shasum": "abc",
+					"size": "1"
+				}
+			}
+		}`))
+	}))
+	defer ts.Close()
+
+	index := NewIndex([]string{ts.URL}, time.Hour, "")
+	ctx := context.Background()
+
+	artifact, found, err := index.Lookup(ctx, "zig-x86_64-linux-0.14.1.tar.xz")
+	if err != nil || !found || artifact.Shasum != "abc" {
+		t.Fatalf("got (%v, %v, %v), want a listed artifact", artifact, found, err)
+	}
+
+	// A miss right after a fetch must not hit upstream again.
+	if _, found, err := index.Lookup(ctx, "zig-aarch64-linux-0.14.1.tar.xz"); err != nil || found {
+		t.Fatalf("got (%v, %v), want an unlisted artifact", found, err)
+	}
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("got %d upstream requests, want 1", got)
+	}
+}
+
+func TestIndexRaw(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	var fail atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"0.14.1": {"version": "0.14.1", "revision": ` + strconv.Itoa(int(requests.Add(1))) + `}}`))
+	}))
+	defer ts.Close()
+
+	cacheFile := filepath.Join(t.TempDir(), "download", "index.json")
+	index := NewIndex([]string{ts.URL}, time.Hour, cacheFile)
+	ctx := context.Background()
+
+	raw, _, err := index.Raw(ctx)
+	if err != nil || !strings.Contains(string(raw), `"revision": 1`) {
+		t.Fatalf("got (%s, %v), want the first revision", raw, err)
+	}
+
+	stored, err := os.ReadFile(cacheFile)
+	if err != nil || string(stored) != string(raw) {
+		t.Fatalf("got stored index.json (%s, %v), want %s", stored, err, raw)
+	}
+
+	// An expired copy is served right away and refreshed in the background.
+	past := time.Now().Add(-2 * time.Hour)
+	index.mu.Lock()
+	index.fetchedAt = past
+	index.mu.Unlock()
+
+	raw, fetchedAt, err := index.Raw(ctx)
+	if err != nil || !strings.Contains(string(raw), `"revision": 1`) || !fetchedAt.Equal(past) {
+		t.Fatalf("got (%s, %v, %v), want the stale first revision", raw, fetchedAt, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		raw, _, _ = index.Raw(ctx)
+		if strings.Contains(string(raw), `"revision": 2`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %s, want the second revision after the background refresh", raw)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A new index backed by the same file starts from the stored copy, even if upstream is down.
+	fail.Store(true)
+	restarted := NewIndex([]string{ts.URL}, time.Hour, cacheFile)
+	raw, _, err = restarted.Raw(ctx)
+	if err != nil || !strings.Contains(string(raw), `"revision": 2`) {
+		t.Fatalf("got (%s, %v), want the stored second revision", raw, err)
+	}
+
+	if got := requests.Load(); got != 2 {
+		t.Errorf("got %d upstream requests, want 2", got)
+	}
+}
+
+func TestIndexSlowRefresh(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		age     time.Duration // of the cached copy
+		refresh func(index *Index)
+	}{
+		{"background refresh", 2 * time.Hour, func(index *Index) { index.Raw(context.Background()) }},
+		{"refetch after a miss", 2 * indexMissRefresh, func(index *Index) {
+			index.Lookup(context.Background(), "zig-aarch64-linux-0.14.1.tar.xz")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests atomic.Int32
+			release := make(chan struct{})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Every request after the first one hangs until the end of the test.
+				if requests.Add(1) > 1 {
+					select {
+					case <-release:
+					case <-r.Context().Done():
+					}
+				}
+				w.Write([]byte(`{"0.14.1": {"version": "0.14.1"}}`))
+			}))
+			t.Cleanup(ts.Close)
+			t.Cleanup(func() { close(release) })
+
+			index := NewIndex([]string{ts.URL}, time.Hour, "")
+			ctx := context.Background()
+			if _, _, err := index.Raw(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			past := time.Now().Add(-tt.age)
+			index.mu.Lock()
+			index.fetchedAt = past
+			index.mu.Unlock()
+
+			go tt.refresh(index)
+			for requests.Load() < 2 {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				raw, fetchedAt, err := index.Raw(ctx)
+				if err != nil || raw == nil || !fetchedAt.Equal(past) {
+					t.Errorf("got (%s, %v, %v), want the stale copy", raw, fetchedAt, err)
+				}
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("got no copy within a second, want the stale copy right away")
+			}
+		})
+	}
+}
+
+func TestIndexSlowFirstFetch(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.Write([]byte(`{"0.14.1": {"version": "0.14.1"}}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	index := NewIndex([]string{ts.URL}, time.Hour, "")
+
+	// Callers without a copy give up once their context ends, even while the fetch keeps running.
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			if _, _, err := index.Raw(ctx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("got an error after %v, want it once the context ends", elapsed)
+			}
+		})
+	}
+	wg.Wait()
+
+	close(release)
+
+	zr, err := index.Releases(context.Background())
+	if err != nil || zr["0.14.1"].Version != "0.14.1" {
+		t.Fatalf("got (%v, %v), want the fetched releases", zr, err)
+	}
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("got %d upstream requests, want 1", got)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	t.Parallel()
+
+	zr := ZigReleases{
+		"0.14.1": Release{
+			Version: "0.14.1",
+			Platforms: map[string]Artifact{
+				"x86_64-linux":  {Tarball: "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz"},
+				"aarch64-macos": {Tarball: "https://ziglang.org/download/0.14.1/zig-aarch64-macos-0.14.1.tar.xz"},
+			},
+		},
+		"master": Release{
+			Version: "0.17.0-dev.305+bdfbf432d",
+			Platforms: map[string]Artifact{
+				"x86_64-linux": {Tarball: "https://ziglang.org/builds/zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		versions  []string
+		platforms []string
+		expected  []string
+	}{
+		{"everything", nil, nil, []string{
+			"zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz",
+			"zig-aarch64-macos-0.14.1.tar.xz",
+			"zig-x86_64-linux-0.14.1.tar.xz",
+		}},
+		{"by release name", []string{"master"}, nil, []string{"zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz"}},
+		{"by release version", []string{"0.17.0-dev.305+bdfbf432d"}, nil, []string{"zig-x86_64-linux-0.17.0-dev.305+bdfbf432d.tar.xz"}},
+		{"by platform", nil, []string{"aarch64-macos"}, []string{"zig-aarch64-macos-0.14.1.tar.xz"}},
+		{"by release and platform", []string{"0.14.1"}, []string{"x86_64-linux"}, []string{"zig-x86_64-linux-0.14.1.tar.xz"}},
+		{"nothing matches", []string{"0.9.1"}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got []string
+			for _, artifact := range zr.Select(tt.versions, tt.platforms) {
+				got = append(got, path.Base(artifact.Tarball))
+			}
+
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package zig
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
 
 // Convert index.json (the file with all Zig releases) to the ZigReleases structure
 // It omits all artifacts without a tarball
@@ -40,3 +45,100 @@ func (r *Release) UnmarshalJSON(data []byte) error {
 	}
 	return nil
 }
+
+// Rewrites the tarball URL of every artifact in index.json so that it points at baseURL
+// (e.g. https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz becomes <baseURL>/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz).
+// Everything else, including fields unknown to Release and Artifact, is kept intact.
+func RewriteTarballs(data []byte, baseURL string) ([]byte, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	var index map[string]json.RawMessage
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+
+	for name, rawRelease := range index {
+		var release Release
+		if err := json.Unmarshal(rawRelease, &release); err != nil {
+			// Not a release, keep it as it is
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rawRelease, &fields); err != nil {
+			return nil, err
+		}
+
+		for platform, artifact := range release.Platforms {
+			u, err := url.Parse(artifact.Tarball)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tarball URL of %s %s: %w", name, platform, err)
+			}
+
+			var artifactFields map[string]json.RawMessage
+			if err := json.Unmarshal(fields[platform], &artifactFields); err != nil {
+				return nil, err
+			}
+
+			tarball, err := json.Marshal(baseURL + u.EscapedPath())
+			if err != nil {
+				return nil, err
+			}
+			artifactFields["tarball"] = tarball
+
+			if fields[platform], err = json.Marshal(artifactFields); err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+		if index[name], err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.MarshalIndent(index, "", "  ")
+}
+
+// Removes the artifacts for which keep returns false from index.json, and the releases that have no artifacts left.
+// Everything else, including fields unknown to Release and Artifact, is kept intact.
+func FilterArtifacts(data []byte, keep func(Artifact) bool) ([]byte, error) {
+	var index map[string]json.RawMessage
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+
+	for name, rawRelease := range index {
+		var release Release
+		if err := json.Unmarshal(rawRelease, &release); err != nil {
+			// Not a release, keep it as it is
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rawRelease, &fields); err != nil {
+			return nil, err
+		}
+
+		kept := 0
+		for platform, artifact := range release.Platforms {
+			if keep(artifact) {
+				kept++
+			} else {
+				delete(fields, platform)
+			}
+		}
+
+		if kept == 0 {
+			delete(index, name)
+			continue
+		}
+
+		var err error
+		if index[name], err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.MarshalIndent(index, "", "  ")
+}
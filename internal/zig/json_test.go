@@ -306,3 +306,136 @@ func TestZigReleases(t *testing.T) {
 		})
 	}
 }
+
+func TestRewriteTarballs(t *testing.T) {
+	t.Parallel()
+
+	input := `{
+		"master": {
+			"version": "0.17.0-dev.305+bdfbf432d",
+			"src": {
+				"tarball": "https://ziglang.org/builds/zig-0.17.0-dev.305+bdfbf432d.tar.xz",
+				"shasum": "abc",
+				"size": "1",
+				"unknown": "kept"
+			}
+		},
+		"0.14.1": {
+			"version": "0.14.1",
+			"future": {"nested": true},
+			"x86_64-linux": {
+				"tarball": "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz",
+				"shasum": "def",
+				"size": "2"
+			}
+		}
+	}`
+
+	output, err := RewriteTarballs([]byte(input), "https://zig.example.com/")
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	zr, err := ParseReleases(output)
+	if err != nil {
+		t.Fatalf("got error %v parsing the rewritten index.json, want nil", err)
+	}
+
+	tests := []struct {
+		release  string
+		platform string
+		expected string
+	}{
+		{"master", "src", "https://zig.example.com/builds/zig-0.17.0-dev.305+bdfbf432d.tar.xz"},
+		{"0.14.1", "x86_64-linux", "https://zig.example.com/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz"},
+	}
+
+	for _, tt := range tests {
+		if got := zr[tt.release].Platforms[tt.platform].Tarball; got != tt.expected {
+			t.Errorf("got tarball %v, want %v", got, tt.expected)
+		}
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(output, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := raw["master"].(map[string]any)["src"].(map[string]any)["unknown"]; got != "kept" {
+		t.Errorf("got unknown artifact field %v, want it to be kept", got)
+	}
+	if got := raw["0.14.1"].(map[string]any)["future"]; !reflect.DeepEqual(got, map[string]any{"nested": true}) {
+		t.Errorf("got unknown release field %v, want it to be kept", got)
+	}
+
+	if _, err := RewriteTarballs([]byte(`{ not valid json }`), "https://zig.example.com"); err == nil {
+		t.Errorf("got nil error for invalid json, want an error")
+	}
+}
+
+func TestFilterArtifacts(t *testing.T) {
+	t.Parallel()
+
+	input := `{
+		"master": {
+			"version": "0.17.0-dev.305+bdfbf432d",
+			"src": {
+				"tarball": "https://ziglang.org/builds/zig-0.17.0-dev.305+bdfbf432d.tar.xz",
+				"shasum": "abc",
+				"size": "1"
+			}
+		},
+		"0.14.1": {
+			"version": "0.14.1",
+			"date": "2025-05-21",
+			"future": {"nested": true},
+			"x86_64-linux": {
+				"tarball": "https://ziglang.org/download/0.14.1/zig-x86_64-linux-0.14.1.tar.xz",
+				"shasum": "def",
+				"size": "2"
+			},
+			"aarch64-linux": {
+				"tarball": "https://ziglang.org/download/0.14.1/zig-aarch64-linux-0.14.1.tar.xz",
+				"shasum": "ghi",
+				"size": "3"
+			}
+		}
+	}`
+
+	output, err := FilterArtifacts([]byte(input), func(a Artifact) bool {
+		return a.Shasum == "def"
+	})
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	var raw map[string]map[string]any
+	if err := json.Unmarshal(output, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := raw["master"]; ok {
+		t.Errorf("got release master without artifacts, want it to be removed")
+	}
+
+	tests := []struct {
+		field    string
+		expected bool
+	}{
+		{"version", true},
+		{"date", true},
+		{"future", true},
+		{"x86_64-linux", true},
+		{"aarch64-linux", false},
+	}
+
+	for _, tt := range tests {
+		if _, ok := raw["0.14.1"][tt.field]; ok != tt.expected {
+			t.Errorf("got field %v present %v, want %v", tt.field, ok, tt.expected)
+		}
+	}
+
+	if _, err := FilterArtifacts([]byte(`{ not valid json }`), func(Artifact) bool { return true }); err == nil {
+		t.Errorf("got nil error for invalid json, want an error")
+	}
+}
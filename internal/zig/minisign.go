@@ -0,0 +1,141 @@
+package zig
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// The public key used to sign official Zig releases.
+// See: https://ziglang.org/download/
+const ZigPublicKey = "RWSGOq2NVecA2UPNdBUZykf1CCb147pkmdtYxgb3Ti+JO/wCYvhbAb/U"
+
+// Minisign signature algorithms.
+// See: https://jedisct1.github.io/minisign/#signature-format
+var (
+	algorithmLegacy    = [2]byte{'E', 'd'} // Ed25519 over the raw file
+	algorithmPrehashed = [2]byte{'E', 'D'} // Ed25519 over the BLAKE2b-512 hash of the file
+)
+
+var (
+	ErrInvalidPublicKey = errors.New("invalid minisign public key")
+	ErrInvalidSignature = errors.New("invalid minisign signature")
+	ErrKeyMismatch      = errors.New("signature was made with a different key")
+	ErrVerification     = errors.New("signature verification failed")
+)
+
+// A minisign public key.
+type PublicKey struct {
+	KeyID [8]byte
+	Key   ed25519.PublicKey
+}
+
+// Parses a base64 encoded minisign public key, e.g. ZigPublicKey.
+func ParsePublicKey(s string) (PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || [2]byte(raw[:2]) != algorithmLegacy {
+		return PublicKey{}, ErrInvalidPublicKey
+	}
+
+	var pk PublicKey
+	copy(pk.KeyID[:], raw[2:10])
+	pk.Key = ed25519.PublicKey(raw[10:])
+
+	return pk, nil
+}
+
+// A parsed .minisig file.
+type Signature struct {
+	Algorithm       [2]byte
+	KeyID           [8]byte
+	Signature       []byte
+	TrustedComment  string
+	GlobalSignature []byte
+}
+
+// Parses the content of a .minisig file.
+func ParseSignature(data []byte) (Signature, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) < 4 {
+		return Signature{}, ErrInvalidSignature
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return Signature{}, ErrInvalidSignature
+	}
+
+	var sig Signature
+	copy(sig.Algorithm[:], raw[:2])
+	copy(sig.KeyID[:], raw[2:10])
+	sig.Signature = raw[10:]
+
+	if sig.Algorithm != algorithmLegacy && sig.Algorithm != algorithmPrehashed {
+		return Signature{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidSignature, sig.Algorithm[:])
+	}
+
+	trustedComment, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return Signature{}, ErrInvalidSignature
+	}
+	sig.TrustedComment = trustedComment
+
+	sig.GlobalSignature, err = base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(sig.GlobalSignature) != ed25519.SignatureSize {
+		return Signature{}, ErrInvalidSignature
+	}
+
+	return sig, nil
+}
+
+// Returns the file name stored in the trusted comment (the "file:" field), or an empty string if there is none.
+// Zig signs it alongside the file to prevent an attacker from serving a different (older) signed artifact.
+func (s Signature) File() string {
+	for field := range strings.SplitSeq(s.TrustedComment, "\t") {
+		if name, ok := strings.CutPrefix(field, "file:"); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// Verifies that the message was signed with the key, including the trusted comment.
+func (pk PublicKey) Verify(sig Signature, message io.Reader) error {
+	if sig.KeyID != pk.KeyID {
+		return ErrKeyMismatch
+	}
+
+	var signed []byte
+	if sig.Algorithm == algorithmPrehashed {
+		hash, err := blake2b.New512(nil)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(hash, message); err != nil {
+			return err
+		}
+		signed = hash.Sum(nil)
+	} else {
+		var err error
+		if signed, err = io.ReadAll(message); err != nil {
+			return err
+		}
+	}
+
+	if !ed25519.Verify(pk.Key, signed, sig.Signature) {
+		return ErrVerification
+	}
+
+	global := bytes.Join([][]byte{sig.Signature, []byte(sig.TrustedComment)}, nil)
+	if !ed25519.Verify(pk.Key, global, sig.GlobalSignature) {
+		return fmt.Errorf("%w: trusted comment", ErrVerification)
+	}
+
+	return nil
+}
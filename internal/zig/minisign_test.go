@@ -0,0 +1,173 @@
+package zig
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signs data the same way minisign does and returns the public key and the .minisig content.
+func testSign(t *testing.T, data []byte, trustedComment string, prehashed bool) (string, []byte) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	algorithm := []byte("Ed")
+	message := data
+	if prehashed {
+		algorithm = []byte("ED")
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	}
+
+	signature := ed25519.Sign(priv, message)
+	global := ed25519.Sign(priv, append(bytes.Clone(signature), trustedComment...))
+
+	publicKey := base64.StdEncoding.EncodeToString(bytes.Join([][]byte{[]byte("Ed"), keyID, pub}, nil))
+	minisig := "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(bytes.Join([][]byte{algorithm, keyID, signature}, nil)) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+
+	return publicKey, []byte(minisig)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		in          string
+		expectError bool
+	}{
+		{"Zig public key", ZigPublicKey, false},
+		{"empty", "", true},
+		{"not base64", "not a key", true},
+		{"too short", "RWSGOq2NVecA2UPN", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParsePublicKey(tt.in)
+			if (err != nil) != tt.expectError {
+				t.Errorf("got error %v, want error %v", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("zig tarball")
+	trustedComment := "timestamp:1718830745\tfile:zig-x86_64-linux-0.14.1.tar.xz\thashed"
+
+	tests := []struct {
+		name      string
+		prehashed bool
+		message   []byte
+		tamper    func(sig []byte) []byte
+		wantErr   error
+	}{
+		{name: "prehashed signature", prehashed: true, message: data},
+		{name: "legacy signature", prehashed: false, message: data},
+		{name: "modified file", prehashed: true, message: []byte("zig tarbalL"), wantErr: ErrVerification},
+		{
+			name:      "modified trusted comment",
+			prehashed: true,
+			message:   data,
+			tamper: func(sig []byte) []byte {
+				return bytes.Replace(sig, []byte("0.14.1"), []byte("0.14.0"), 1)
+			},
+			wantErr: ErrVerification,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			publicKey, minisig := testSign(t, data, trustedComment, tt.prehashed)
+			if tt.tamper != nil {
+				minisig = tt.tamper(minisig)
+			}
+
+			pk, err := ParsePublicKey(publicKey)
+			if err != nil {
+				t.Fatalf("failed to parse the public key: %v", err)
+			}
+
+			sig, err := ParseSignature(minisig)
+			if err != nil {
+				t.Fatalf("failed to parse the signature: %v", err)
+			}
+
+			err = pk.Verify(sig, bytes.NewReader(tt.message))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("different key", func(t *testing.T) {
+		t.Parallel()
+
+		_, minisig := testSign(t, data, trustedComment, true)
+		sig, err := ParseSignature(minisig)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pk, err := ParsePublicKey(ZigPublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := pk.Verify(sig, bytes.NewReader(data)); !errors.Is(err, ErrKeyMismatch) {
+			t.Errorf("got error %v, want %v", err, ErrKeyMismatch)
+		}
+	})
+}
+
+func TestParseSignature(t *testing.T) {
+	t.Parallel()
+
+	_, valid := testSign(t, []byte("data"), "timestamp:1\tfile:zig-0.14.1.tar.xz\thashed", true)
+
+	tests := []struct {
+		name         string
+		in           []byte
+		expectError  bool
+		expectedFile string
+	}{
+		{"valid signature", valid, false, "zig-0.14.1.tar.xz"},
+		{"CRLF line endings", []byte(strings.ReplaceAll(string(valid), "\n", "\r\n")), false, "zig-0.14.1.tar.xz"},
+		{"empty", nil, true, ""},
+		{"garbage", []byte("untrusted comment: x\nnot base64\ntrusted comment: y\nzzz"), true, ""},
+		{"missing trusted comment", []byte(strings.Replace(string(valid), "\ntrusted comment: ", "\ncomment: ", 1)), true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			sig, err := ParseSignature(tt.in)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("got error %v, want error %v", err, tt.expectError)
+			}
+			if got := sig.File(); got != tt.expectedFile {
+				t.Errorf("got file %q, want %q", got, tt.expectedFile)
+			}
+		})
+	}
+}
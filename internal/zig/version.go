@@ -0,0 +1,60 @@
+package zig
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Compares two Zig versions (e.g. 0.14.1 or 0.17.0-dev.305+bdfbf432d).
+// It returns -1 if a is older than b, 1 if a is newer than b and 0 if they are equal.
+// A dev build is older than the release it leads up to.
+func CompareVersions(a, b string) int {
+	aCore, aDev := splitVersion(a)
+	bCore, bDev := splitVersion(b)
+
+	for i := range 3 {
+		if aCore[i] != bCore[i] {
+			return compareInts(aCore[i], bCore[i])
+		}
+	}
+
+	switch {
+	case aDev < 0 && bDev < 0:
+		return 0
+	case aDev < 0:
+		return 1
+	case bDev < 0:
+		return -1
+	}
+
+	return compareInts(aDev, bDev)
+}
+
+// Splits a version into major, minor and patch numbers and the dev build number (-1 for releases).
+// Unparsable parts are treated as zero.
+func splitVersion(v string) ([3]int, int) {
+	v, _, _ = strings.Cut(v, "+")
+	core, dev, isDev := strings.Cut(v, "-dev.")
+
+	var numbers [3]int
+	for i, part := range strings.SplitN(core, ".", 3) {
+		numbers[i], _ = strconv.Atoi(part)
+	}
+
+	devNumber := -1
+	if isDev {
+		devNumber, _ = strconv.Atoi(dev)
+	}
+
+	return numbers, devNumber
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
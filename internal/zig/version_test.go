@@ -0,0 +1,32 @@
+package zig
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"0.14.1", "0.14.1", 0},
+		{"0.14.0", "0.14.1", -1},
+		{"0.14.1", "0.14.0", 1},
+		{"0.9.1", "0.10.0", -1},
+		{"1.0.0", "0.16.0", 1},
+		{"0.17.0-dev.305+bdfbf432d", "0.16.0", 1},
+		{"0.17.0-dev.305+bdfbf432d", "0.17.0", -1},
+		{"0.17.0", "0.17.0-dev.305+bdfbf432d", 1},
+		{"0.17.0-dev.305+bdfbf432d", "0.17.0-dev.1024+aaaaaaaaa", -1},
+		{"0.17.0-dev.305+bdfbf432d", "0.17.0-dev.305+ccccccccc", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			t.Parallel()
+			if got := CompareVersions(tt.a, tt.b); got != tt.expected {
+				t.Errorf("got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
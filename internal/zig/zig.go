@@ -3,6 +3,7 @@ package zig
 import (
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 // Matches standard Zig distribution filenames, capturing the version string.
@@ -29,6 +30,21 @@ func ArtifactSubmatches(s string) []string {
 	return matches
 }
 
+// Extracts the platform from a Zig artifact filename (e.g. x86_64-linux, bootstrap, or src for the source tarball).
+// Returns an empty string if the provided string does not follow the official Zig artifact naming convention.
+func ArtifactPlatform(s string) string {
+	matches := ArtifactSubmatches(s)
+	if matches == nil {
+		return ""
+	}
+
+	platform := strings.TrimPrefix(s[:strings.LastIndex(s, "-"+matches[1])], "zig")
+	if platform == "" {
+		return "src"
+	}
+	return strings.TrimPrefix(platform, "-")
+}
+
 // A Zig artifact from index.json
 type Artifact struct {
 	Tarball string
@@ -172,6 +172,34 @@ func TestArtifactSubmatches(t *testing.T) {
 	}
 }
 
+func TestArtifactPlatform(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"", ""},
+		{"zig-0.14.1.tarxz", ""},
+		{"zig-0.14.1.tar.xz", "src"},
+		{"zig-bootstrap-0.14.1.tar.xz", "bootstrap"},
+		{"zig-x86_64-linux-0.14.1.tar.xz", "x86_64-linux"},
+		{"zig-x86_64-windows-0.14.1.zip", "x86_64-windows"},
+		{"zig-linux-x86_64-0.7.1.tar.xz", "linux-x86_64"},
+		{"zig-aarch64-macos-0.17.0-dev.305+bdfbf432d.tar.xz", "aarch64-macos"},
+		{"zig-aarch64-macos-0.14.1.tar.xz.minisig", "aarch64-macos"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			if got := ArtifactPlatform(tt.in); got != tt.expected {
+				t.Errorf("got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestTotalSize(t *testing.T) {
 	t.Parallel()
 